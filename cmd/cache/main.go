@@ -26,9 +26,9 @@ func main() {
 		log.Fatalf("couldn't read config: %v", err)
 	}
 
-	cacheSvc := service.MakeCacheService(config.RedisURL)
+	cacheSvc := service.MakeBackend(config)
 	requestSvc := service.MakeRequestService(config, cacheSvc)
-	grpcServer := transport.InitGRPCServer(requestSvc)
+	grpcServer := transport.InitGRPCServer(config, requestSvc)
 
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
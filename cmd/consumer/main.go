@@ -7,20 +7,47 @@ import (
 	"ikit-cache/internal/transport/proto"
 	"io"
 	"log"
+	"strings"
 	"sync"
 
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func main() {
 	host := flag.String("h", "127.0.0.1", "server host")
 	port := flag.Int("p", 50051, "server port")
 	numConsumers := flag.Int("c", 10, "number of consumers")
+	count := flag.Int("n", 0, "number of urls per consumer (0 - default of server)")
+	urls := flag.String("urls", "", "comma separated urls")
+	groups := flag.String("groups", "", "comma separated url groups")
+	maxAge := flag.Duration("max-age", 0, "max age of cached data (0 - any age)")
+	bypassCache := flag.Bool("bypass-cache", false, "bypass cache")
+	getURL := flag.String("get", "", "get url instead of random data stream")
+	getMany := flag.Bool("many", false, "get urls instead of random data stream")
+	inOrder := flag.Bool("in-order", false, "get urls in order of request instead of completion order")
+	maxMessageSize := flag.Int("max-message-size", 4<<20, "max size of received message (MaxMessageSize of server)")
 
 	flag.Parse()
 
+	req := &proto.GetRandomDataStreamRequest{
+		Count:       int32(*count),
+		Urls:        splitList(*urls),
+		Groups:      splitList(*groups),
+		BypassCache: *bypassCache,
+	}
+
+	if *maxAge > 0 {
+		req.MaxAge = durationpb.New(*maxAge)
+	}
+
 	log.Println("before connect")
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", *host, *port), grpc.WithInsecure(), grpc.WithBlock())
+	conn, err := grpc.Dial(
+		fmt.Sprintf("%s:%d", *host, *port),
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(*maxMessageSize)),
+	)
 	log.Println("after connect")
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
@@ -32,19 +59,90 @@ func main() {
 
 	wg.Add(*numConsumers)
 	for i := 0; i < *numConsumers; i++ {
-		go request(wg, client)
+		if *getURL != "" {
+			go get(wg, client, &proto.GetRequest{Url: *getURL, MaxAge: req.MaxAge, BypassCache: *bypassCache})
+		} else if *getMany {
+			manyReq := &proto.GetManyRequest{Urls: req.Urls, MaxAge: req.MaxAge, BypassCache: *bypassCache}
+			if *inOrder {
+				manyReq.Order = proto.Order_ORDER_REQUEST
+			}
+
+			go many(wg, client, manyReq)
+		} else {
+			go request(wg, client, req)
+		}
 	}
 
 	wg.Wait()
 }
 
-func request(wg *sync.WaitGroup, client proto.RandomServiceClient) {
+func get(wg *sync.WaitGroup, client proto.RandomServiceClient, req *proto.GetRequest) {
+	defer wg.Done()
+
+	resp, err := client.Get(context.Background(), req)
+	if err != nil {
+		log.Printf("couldn't get %s: %v\n", req.GetUrl(), err)
+		return
+	}
+
+	logGetResponse("", resp)
+}
+
+func many(wg *sync.WaitGroup, client proto.RandomServiceClient, req *proto.GetManyRequest) {
+	defer wg.Done()
+
+	stream, err := client.GetMany(context.Background(), req)
+	if err != nil {
+		log.Printf("couldn't get stream: %v\n", err)
+		return
+	}
+
+	for {
+		resp, err := stream.Recv()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			log.Printf("couldn't get response: %v\n", err)
+			break
+		}
+
+		for _, item := range resp.GetItems() {
+			// body of large response is continued in next items
+			if item.GetChunkIndex() > 0 {
+				continue
+			}
+
+			logGetResponse(fmt.Sprintf("%d (%d chunks): ", item.GetIndex(), item.GetChunkCount()), item.GetResponse())
+		}
+	}
+}
+
+func logGetResponse(prefix string, resp *proto.GetResponse) {
+	metadata := resp.GetMetadata()
+	if respErr := resp.GetError(); respErr != nil {
+		log.Printf("%s%s %s, error %s: %s", prefix, metadata.GetUrl(), metadata.GetSource(), respErr.GetCode(), respErr.GetMessage())
+		return
+	}
+
+	log.Printf("%s%s %s, status %d, %d bytes", prefix, metadata.GetUrl(), metadata.GetSource(), metadata.GetStatusCode(), len(resp.GetBody()))
+}
+
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
+
+func request(wg *sync.WaitGroup, client proto.RandomServiceClient, req *proto.GetRandomDataStreamRequest) {
 	defer wg.Done()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	stream, err := client.GetRandomDataStream(ctx, &proto.GetRandomDataStreamRequest{})
+	stream, err := client.GetRandomDataStream(ctx, req)
 
 	if err != nil {
 		log.Printf("couldn't get stream: %v\n", err)
@@ -53,7 +151,7 @@ func request(wg *sync.WaitGroup, client proto.RandomServiceClient) {
 
 	i := 0
 	for {
-		_, err := stream.Recv()
+		resp, err := stream.Recv()
 
 		if err == io.EOF {
 			break
@@ -62,7 +160,30 @@ func request(wg *sync.WaitGroup, client proto.RandomServiceClient) {
 			break
 		}
 
-		log.Println(i)
+		// body of large response is continued in next messages
+		if resp.GetChunkIndex() > 0 {
+			continue
+		}
+
+		metadata := resp.GetMetadata()
+		if respErr := resp.GetError(); respErr != nil {
+			log.Printf("%d: %s %s, error %s: %s", i, metadata.GetUrl(), metadata.GetSource(), respErr.GetCode(), respErr.GetMessage())
+			i += 1
+			continue
+		}
+
+		log.Printf(
+			"%d: %s %s, status %d, %s, age %s, ttl %s, latency %s, %d chunks",
+			i,
+			metadata.GetUrl(),
+			metadata.GetSource(),
+			metadata.GetStatusCode(),
+			metadata.GetContentType(),
+			metadata.GetAge().AsDuration(),
+			metadata.GetTtl().AsDuration(),
+			metadata.GetFetchLatency().AsDuration(),
+			resp.GetChunkCount(),
+		)
 		i += 1
 	}
 }
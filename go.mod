@@ -3,6 +3,7 @@ module ikit-cache
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.23.0
 	github.com/go-redis/redis/v8 v8.7.1
 	github.com/golang/protobuf v1.4.2
 	github.com/stretchr/testify v1.7.0
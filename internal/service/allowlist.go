@@ -0,0 +1,120 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+/* allowlist of Get
+
+patterns of AllowedURLs:
+	1. url ("https://golang.org/doc/") -> normalized url has prefix of normalized pattern
+	   which ends at path segment ("https://golang.org/doc" allows "/doc/install", but not "/documents"),
+	   url with dot segments ("/doc/../admin", "/doc/%2e%2e/admin") isn't matched
+	2. host ("golang.org", "*.google.com") -> host of url matches pattern (path.Match)
+
+URLs and URLGroups of config are always allowed and their redirects are followed,
+redirects of other urls are followed only to allowed urls
+*/
+
+type allowlist struct {
+	prefixes []string
+	hosts    []string
+	// normalized URLs and urls of URLGroups
+	urls map[string]bool
+}
+
+func makeAllowlist(patterns []string, urls []string) (allowlist, error) {
+	al := allowlist{
+		urls: make(map[string]bool, len(urls)),
+	}
+
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "://") {
+			prefix, err := normalizeURL(pattern)
+			if err != nil {
+				return allowlist{}, fmt.Errorf("invalid URL pattern %q: %v", pattern, err)
+			}
+
+			al.prefixes = append(al.prefixes, prefix)
+			continue
+		}
+
+		host := strings.ToLower(pattern)
+		if _, err := path.Match(host, ""); err != nil {
+			return allowlist{}, fmt.Errorf("invalid host pattern %q: %v", pattern, err)
+		}
+
+		al.hosts = append(al.hosts, host)
+	}
+
+	for _, allowedURL := range urls {
+		normalizedURL, err := normalizeURL(allowedURL)
+		if err != nil {
+			return allowlist{}, fmt.Errorf("invalid URL %q: %v", allowedURL, err)
+		}
+
+		al.urls[normalizedURL] = true
+	}
+
+	return al, nil
+}
+
+// isAllowed checks normalized url
+func (al allowlist) isAllowed(normalizedURL string) bool {
+	if al.urls[normalizedURL] {
+		return true
+	}
+
+	u, err := url.Parse(normalizedURL)
+	if err != nil {
+		return false
+	}
+
+	if !hasDotSegments(u.Path) {
+		for _, prefix := range al.prefixes {
+			if hasPathPrefix(normalizedURL, prefix) {
+				return true
+			}
+		}
+	}
+
+	for _, host := range al.hosts {
+		if isMatched, _ := path.Match(host, u.Hostname()); isMatched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasPathPrefix checks that prefix ends at path segment or query param
+func hasPathPrefix(normalizedURL, prefix string) bool {
+	if !strings.HasPrefix(normalizedURL, prefix) {
+		return false
+	}
+
+	if len(normalizedURL) == len(prefix) || strings.HasSuffix(prefix, "/") {
+		return true
+	}
+
+	separators := "/?"
+	if strings.Contains(prefix, "?") {
+		separators = "&"
+	}
+
+	return strings.IndexByte(separators, normalizedURL[len(prefix)]) >= 0
+}
+
+// hasDotSegments checks decoded path, so percent-encoded dot segments are found too
+func hasDotSegments(urlPath string) bool {
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+
+	return false
+}
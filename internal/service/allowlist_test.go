@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowlist(t *testing.T) {
+	al, err := makeAllowlist(
+		[]string{"https://golang.org/doc/", "https://golang.org/src", "https://golang.org/?q=a", "*.google.com", "GitHub.com"},
+		[]string{"https://www.bbc.co.uk"},
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://golang.org/doc/install", true},
+		{"https://golang.org/pkg/", false},
+		{"https://golang.org/doc/../admin", false},
+		{"https://golang.org/doc/%2e%2e/admin", false},
+		{"https://golang.org/doc/%2E%2E%2Fadmin", false},
+		{"https://golang.org/doc/./install", false},
+		{"https://golang.org/src", true},
+		{"https://golang.org/src/net/", true},
+		{"https://golang.org/src?h=master", true},
+		{"https://golang.org/srcs-private", false},
+		{"https://golang.org/?q=a&lang=go", true},
+		{"https://golang.org/?q=admin", false},
+		{"https://www.google.com/", true},
+		{"https://google.com/", false},
+		{"http://github.com:8080/jokly", true},
+		{"https://www.bbc.co.uk/", true},
+		{"https://www.bbc.co.uk/news", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.want, al.isAllowed(test.url), test.url)
+	}
+
+	_, err = makeAllowlist([]string{"[a-"}, nil)
+	assert.Error(t, err)
+}
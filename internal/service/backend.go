@@ -0,0 +1,66 @@
+package service
+
+import (
+	"errors"
+	"ikit-cache/internal/util"
+	"log"
+	"time"
+)
+
+const (
+	RedisBackend  = "redis"
+	MemoryBackend = "memory"
+
+	lastKeySuffix = ":last"
+)
+
+var (
+	ErrCacheMiss  = errors.New("cache miss")
+	ErrStaleToken = errors.New("fencing token is older than token of last write")
+)
+
+type Cache interface {
+	GetResponse(url string) (Response, error)
+	// SetResponse rejects write with ErrStaleToken if response was already written
+	// with newer fencing token
+	SetResponse(url string, response Response, expiration time.Duration, token int64) error
+	// last successful response of url is kept separately to be served if origin fails,
+	// its write is fenced the same as SetResponse
+	GetLastResponse(url string) (Response, error)
+	SetLastResponse(url string, response Response, expiration time.Duration, token int64) error
+}
+
+type Locker interface {
+	IsLock(url string) (bool, error)
+	// Lock returns monotonically increasing fencing token of url if lock is taken
+	Lock(url, value string, expiration time.Duration) (int64, bool, error)
+	Unlock(url, value string) error
+	// Extend resets expiration of lock only if it's owned by value
+	Extend(url, value string, expiration time.Duration) (bool, error)
+}
+
+type Backend interface {
+	Cache
+	Locker
+}
+
+func MakeBackend(config *util.Config) Backend {
+	switch config.CacheBackend {
+	case "", RedisBackend:
+		return MakeCacheService(config)
+	case MemoryBackend:
+		return MakeMemoryCacheService(config.MemoryMaxEntries, config.MemoryMaxBytes)
+	default:
+		log.Fatalf("unknown cache backend: %s", config.CacheBackend)
+	}
+
+	return nil
+}
+
+var (
+	_ Backend = (*CacheService)(nil)
+	_ Backend = (*MemoryCacheService)(nil)
+
+	_ UnlockNotifier = (*CacheService)(nil)
+	_ UnlockNotifier = (*MemoryCacheService)(nil)
+)
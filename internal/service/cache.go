@@ -2,30 +2,148 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"ikit-cache/internal/util"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-/* cache strategy (redlock)
+/* cache strategy (redlock, see redlock.go)
 
 (x) get response from cache:
 	1. exists -> send response to channel
 	2. not exists -> set lock (set nx)
 		1. successfull -> http request -> send response to channel -> add cache -> unset lock
 		2. fail (lock exist) -> wait until unlock -> go to (x)
+
+concurrent (x) of the same url on one node are coalesced, so only one of them touches cache
+
+stale while revalidate:
+	1. response is kept in cache for stale window after it's expired
+	2. stale response exists -> send stale response to channel -> try set lock in background
+		1. successfull -> http request -> add cache -> unset lock
+		2. fail (lock exist) -> response is already revalidated by lock holder
+
+conditional revalidation:
+	1. stale or last response has ETag / Last-Modified -> http request with If-None-Match / If-Modified-Since
+	2. 304 Not Modified -> add stale or last response to cache with new expiration
+
+negative cache:
+	1. error response -> add cache for NegativeTimeout doubled for every consecutive failure up to MaxNegativeTimeout
+	2. expired error response is kept in cache for MaxNegativeTimeout to count consecutive failures,
+	   it isn't served as stale response
+
+stale if error:
+	1. successfull http request -> add last response to cache for stale if error window after it's expired
+	2. error response (from cache or http request) -> send last response as stale to channel
+
+local cache (L1):
+	1. get -> local cache -> redis (get + pttl) -> save to local cache for min(local ttl, redis ttl)
+	2. set -> redis -> publish url to invalidate channel -> every node removes url from local cache
+	3. deleted or expired redis keys are removed from local cache by keyspace notifications
+	   (work only if notify-keyspace-events is enabled on redis server)
+
+fencing tokens:
+	1. lock -> increment token (one for all urls, so it's monotonic for every url) -> lock holder gets token
+	2. set response with token -> rejected if token of last write is newer
+	3. token of last write expires with response, there is nothing to protect after it
+
+lock lease renewal:
+	1. lock holder extends lock (only if it's owned by holder) while HTTP request is in progress
+	2. extension stops after HTTP request or if lock is lost
+
+unlock notifications:
+	1. unlock or set response -> publish url to unlock channel
+	2. waiter subscribes to url -> checks lock -> waits for notification or timeout
+	3. waiters fall back to polling until subscription to unlock channel is confirmed
+	4. lock couldn't be taken but it isn't held -> retry after jittered backoff
+
+large bodies:
+	1. body is read up to MaxBodySize -> larger response is error or truncated (TruncateBody)
+	2. body larger than BodyChunkSize -> chunks are set with new chunk id -> entry refers to chunk id
+	3. get entry -> get chunks -> any chunk is missed -> cache miss
+	4. set entry -> previous entry is returned by set script -> its chunks expire after chunkGracePeriod
+	   (readers which got previous entry could still get its chunks)
+
+keys and channels are prefixed by KeyPrefix to share redis with other services or deployments
 */
 
 type Response struct {
-	Body    string `json:"response"`
+	Body    []byte `json:"response"`
 	IsError bool   `json:"is_error"`
+	// ErrorCode is set for error response, its body is error message if HTTP request is failed
+	ErrorCode ErrorCode `json:"error_code,omitempty"`
+	// HTTP response metadata, StatusCode is 0 if HTTP request is failed
+	StatusCode   int           `json:"status_code,omitempty"`
+	Header       http.Header   `json:"header,omitempty"`
+	ContentType  string        `json:"content_type,omitempty"`
+	FetchedAt    time.Time     `json:"fetched_at"`
+	FetchLatency time.Duration `json:"fetch_latency"`
+	// response is fresh until ExpiresAt and stale after it until it's deleted from cache
+	ExpiresAt time.Time `json:"expires_at"`
+	// validators for conditional revalidation
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Failures is a number of consecutive failures of error response
+	Failures int `json:"failures,omitempty"`
+	// Truncated is set if body is larger than MaxBodySize and is truncated
+	Truncated bool `json:"truncated,omitempty"`
+	// Stale is set for expired responses which are served instead of fresh one
+	Stale bool `json:"-"`
+	// URL is normalized url of response and Source is where it's got from, they aren't cached
+	URL    string `json:"-"`
+	Source Source `json:"-"`
 }
 
+type Source int
+
+const (
+	// SourceMiss is response of HTTP request
+	SourceMiss Source = iota
+	// SourceHit is fresh response from cache
+	SourceHit
+	// SourceStale is expired response from cache or last response instead of error
+	SourceStale
+	// SourceCoalesced is response of concurrent caller of the same url
+	SourceCoalesced
+)
+
+type ErrorCode int
+
 const (
-	lockKeySuffix = ":lock"
+	// ErrorCodeUnknown is code of error responses cached without code
+	ErrorCodeUnknown ErrorCode = iota
+	// ErrorCodeStatus is unsuccessful HTTP status by status policy
+	ErrorCodeStatus
+	ErrorCodeTimeout
+	// ErrorCodeRequest is any other failure of HTTP request
+	ErrorCodeRequest
+	ErrorCodeBodyTooLarge
+)
+
+// IsStale returns false for responses cached without ExpiresAt
+func (r Response) IsStale(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+const (
+	lockKeySuffix       = ":lock"
+	writeTokenKeySuffix = ":token:write"
+	chunkKeySuffix      = ":chunk:"
+
+	// chunks of overwritten entry are kept for chunkGracePeriod
+	chunkGracePeriod = 5 * time.Second
+
+	tokenKeyName          = "ikit-cache:token"
+	invalidateChannelName = "ikit-cache:invalidate"
+	unlockChannelName     = "ikit-cache:unlock"
 )
 
 var (
@@ -38,79 +156,437 @@ var (
 			return 0
 		end
 	`
+
+	// token of last write expires with response, previous entry is returned to expire its chunks
+	setScript = `
+		local token = tonumber(ARGV[3])
+		if token < tonumber(redis.call("GET", KEYS[2]) or "0") then
+			return {0, ""}
+		end
+
+		local previous = redis.call("GET", KEYS[1]) or ""
+		redis.call("SET", KEYS[2], token, "PX", ARGV[2])
+		redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+		return {1, previous}
+	`
+
+	extendScript = `
+		if redis.call("GET", KEYS[1]) == ARGV[1] then
+			return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+		else
+			return 0
+		end
+	`
 )
 
 type CacheService struct {
-	rdb *redis.Client
+	rdb       *redis.Client
+	codec     entryCodec
+	chunkSize int
+	prefix    string
+	locker    *redlock
+	local     *localCache
+	waiters   *unlockWaiters
 }
 
-func MakeCacheService(redisURL string) *CacheService {
-	opt, err := redis.ParseURL(redisURL)
+func MakeCacheService(config *util.Config) *CacheService {
+	opt, err := redis.ParseURL(config.RedisURL)
 	if err != nil {
 		log.Fatalf("couldn't parse redis URL: %v", err)
 	}
 
-	return &CacheService{
-		rdb: redis.NewClient(opt),
+	codec, err := makeEntryCodec(config.Compression, config.CompressionThreshold)
+	if err != nil {
+		log.Fatalf("couldn't create cache entry codec: %v", err)
+	}
+
+	cs := &CacheService{
+		rdb:       redis.NewClient(opt),
+		codec:     codec,
+		chunkSize: config.BodyChunkSize,
+		prefix:    config.KeyPrefix,
+		waiters:   newUnlockWaiters(false),
 	}
+
+	// lock is taken on the same redis as cache if no independent lock nodes are configured
+	lockClients := []*redis.Client{cs.rdb}
+	if len(config.RedisLockURLs) > 0 {
+		lockClients = make([]*redis.Client, 0, len(config.RedisLockURLs))
+		for _, lockURL := range config.RedisLockURLs {
+			lockOpt, err := redis.ParseURL(lockURL)
+			if err != nil {
+				log.Fatalf("couldn't parse redis lock URL: %v", err)
+			}
+
+			lockClients = append(lockClients, redis.NewClient(lockOpt))
+		}
+	}
+	cs.locker = newRedlock(lockClients)
+
+	channels := []string{cs.getUnlockChannel()}
+	if config.LocalCacheTTL > 0 {
+		cs.local = newLocalCache(
+			time.Duration(config.LocalCacheTTL)*time.Second,
+			config.LocalCacheMaxEntries,
+			config.LocalCacheMaxBytes,
+		)
+
+		channels = append(
+			channels,
+			cs.getInvalidateChannel(),
+			fmt.Sprintf("__keyevent@%d__:del", opt.DB),
+			fmt.Sprintf("__keyevent@%d__:expired", opt.DB),
+			fmt.Sprintf("__keyevent@%d__:evicted", opt.DB),
+		)
+	}
+
+	go cs.listen(channels)
+
+	return cs
 }
 
 func (cs *CacheService) IsLock(url string) (bool, error) {
-	cmd := cs.rdb.Get(ctx, cs.getLockKey(url))
-	if cmd.Err() != nil {
-		if errors.Is(cmd.Err(), redis.Nil) {
-			return false, nil
+	return cs.locker.isLocked(cs.getLockKey(url))
+}
+
+func (cs *CacheService) Lock(url, value string, expiration time.Duration) (int64, bool, error) {
+	isTakeLock, err := cs.locker.lock(cs.getLockKey(url), value, expiration)
+	if !isTakeLock {
+		return 0, false, err
+	}
+
+	token, err := cs.rdb.Incr(ctx, cs.getTokenKey()).Result()
+	if err != nil {
+		if err := cs.locker.unlock(cs.getLockKey(url), value); err != nil {
+			log.Printf("couldn't delete lock for %s: %v", url, err)
 		}
 
-		return false, cmd.Err()
+		return 0, false, err
 	}
 
-	return true, nil
+	return token, true, nil
 }
 
-func (cs *CacheService) Lock(url, value string, expiration time.Duration) (bool, error) {
-	cmd := cs.rdb.SetNX(ctx, cs.getLockKey(url), value, expiration)
+func (cs *CacheService) Unlock(url, value string) error {
+	err := cs.locker.unlock(cs.getLockKey(url), value)
+
+	// lock could be released on majority of nodes even with error
+	cs.publishUnlock(url)
 
-	return cmd.Val(), cmd.Err()
+	return err
 }
 
-func (cs *CacheService) Unlock(url, value string) error {
-	cmd := cs.rdb.Eval(
-		ctx,
-		deleteScript,
-		[]string{cs.getLockKey(url)},
-		value,
-	)
+func (cs *CacheService) Extend(url, value string, expiration time.Duration) (bool, error) {
+	return cs.locker.extend(cs.getLockKey(url), value, expiration)
+}
 
-	return cmd.Err()
+func (cs *CacheService) SubscribeUnlock(url string) (<-chan struct{}, func(), error) {
+	return cs.waiters.subscribe(url)
 }
 
 func (cs *CacheService) GetResponse(url string) (Response, error) {
-	respJSON, err := cs.rdb.Get(ctx, url).Result()
+	if cs.local != nil {
+		if resp, ok := cs.local.get(url); ok {
+			return resp, nil
+		}
+	}
+
+	entry, ttl, err := cs.get(cs.getKey(url))
 
 	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return Response{}, ErrCacheMiss
+		}
+
 		return Response{}, err
 	}
 
-	resp := Response{}
-	if err := json.Unmarshal([]byte(respJSON), &resp); err != nil {
+	resp, err := cs.decode(cs.getKey(url), entry)
+	if err != nil {
 		return resp, err
 	}
 
+	if cs.local != nil {
+		cs.local.set(url, resp, ttl)
+	}
+
 	return resp, nil
 }
 
-func (cs *CacheService) SetResponse(url string, response Response, expiration time.Duration) error {
-	respJSON, err := json.Marshal(response)
+func (cs *CacheService) GetLastResponse(url string) (Response, error) {
+	entry, err := cs.rdb.Get(ctx, cs.getLastKey(url)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return Response{}, ErrCacheMiss
+		}
+
+		return Response{}, err
+	}
+
+	return cs.decode(cs.getLastKey(url), entry)
+}
+
+func (cs *CacheService) SetLastResponse(url string, response Response, expiration time.Duration, token int64) error {
+	return cs.set(cs.getLastKey(url), response, expiration, token)
+}
+
+func (cs *CacheService) SetResponse(url string, response Response, expiration time.Duration, token int64) error {
+	if err := cs.set(cs.getKey(url), response, expiration, token); err != nil {
+		return err
+	}
+
+	if cs.local != nil {
+		cs.local.remove(url)
+
+		if err := cs.rdb.Publish(ctx, cs.getInvalidateChannel(), url).Err(); err != nil {
+			log.Printf("couldn't publish invalidation for %s: %v", url, err)
+		}
+	}
+
+	cs.publishUnlock(url)
+
+	return nil
+}
+
+// set sets response to key if token isn't older than token of last write to key
+func (cs *CacheService) set(key string, response Response, expiration time.Duration, token int64) error {
+	entry, chunkKeys, err := cs.encode(key, response, expiration)
+	if err != nil {
+		return err
+	}
+
+	reply, err := cs.rdb.Eval(
+		ctx,
+		setScript,
+		[]string{key, cs.getWriteTokenKey(key)},
+		entry,
+		expiration.Milliseconds(),
+		token,
+	).Result()
 	if err != nil {
 		return err
 	}
 
-	cmd := cs.rdb.SetEX(ctx, url, respJSON, expiration)
+	result, ok := reply.([]interface{})
+	if !ok || len(result) != 2 {
+		return fmt.Errorf("unexpected reply of set script: %v", reply)
+	}
+
+	if isSet, _ := result[0].(int64); isSet == 0 {
+		if len(chunkKeys) > 0 {
+			if err := cs.rdb.Del(ctx, chunkKeys...).Err(); err != nil {
+				log.Printf("couldn't delete chunks of %s: %v", key, err)
+			}
+		}
+
+		return ErrStaleToken
+	}
+
+	if previous, _ := result[1].(string); previous != "" {
+		cs.expireChunks(key, []byte(previous))
+	}
+
+	return nil
+}
+
+// expireChunks shortens ttl of chunks of overwritten entry to chunkGracePeriod
+func (cs *CacheService) expireChunks(key string, entry []byte) {
+	_, chunks, err := cs.codec.decodeChunked(entry)
+	if err != nil {
+		log.Printf("couldn't decode overwritten entry of %s: %v", key, err)
+		return
+	}
+
+	if chunks.count == 0 {
+		return
+	}
+
+	pipe := cs.rdb.Pipeline()
+	for i := 0; i < chunks.count; i++ {
+		pipe.PExpire(ctx, cs.getChunkKey(key, chunks.id, i), chunkGracePeriod)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("couldn't expire chunks of overwritten entry of %s: %v", key, err)
+	}
+}
+
+func (cs *CacheService) publishUnlock(url string) {
+	if err := cs.rdb.Publish(ctx, cs.getUnlockChannel(), url).Err(); err != nil {
+		log.Printf("couldn't publish unlock for %s: %v", url, err)
+	}
+}
+
+// encode sets chunks of large body which expire with entry and returns entry with chunk keys
+func (cs *CacheService) encode(key string, response Response, expiration time.Duration) ([]byte, []string, error) {
+	if cs.chunkSize <= 0 || len(response.Body) <= cs.chunkSize {
+		entry, err := cs.codec.encode(response)
+		return entry, nil, err
+	}
+
+	chunkID, err := getRandomChunkID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunks := chunkRef{
+		id:    chunkID,
+		count: (len(response.Body) + cs.chunkSize - 1) / cs.chunkSize,
+	}
+	chunkKeys := make([]string, 0, chunks.count)
+
+	pipe := cs.rdb.Pipeline()
+	for i := 0; i < chunks.count; i++ {
+		end := (i + 1) * cs.chunkSize
+		if end > len(response.Body) {
+			end = len(response.Body)
+		}
+
+		chunk, err := cs.codec.encodeChunk(response.Body[i*cs.chunkSize : end])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		chunkKey := cs.getChunkKey(key, chunks.id, i)
+		pipe.Set(ctx, chunkKey, chunk, expiration)
+		chunkKeys = append(chunkKeys, chunkKey)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	response.Body = nil
+	entry, err := cs.codec.encodeChunked(response, chunks)
+
+	return entry, chunkKeys, err
+}
+
+// decode gets chunks of large body, missed chunk is cache miss
+func (cs *CacheService) decode(key string, entry []byte) (Response, error) {
+	resp, chunks, err := cs.codec.decodeChunked(entry)
+	if err != nil || chunks.count == 0 {
+		return resp, err
+	}
+
+	chunkKeys := make([]string, 0, chunks.count)
+	for i := 0; i < chunks.count; i++ {
+		chunkKeys = append(chunkKeys, cs.getChunkKey(key, chunks.id, i))
+	}
+
+	values, err := cs.rdb.MGet(ctx, chunkKeys...).Result()
+	if err != nil {
+		return Response{}, err
+	}
+
+	var body []byte
+	for _, value := range values {
+		encoded, ok := value.(string)
+		if !ok {
+			return Response{}, ErrCacheMiss
+		}
+
+		chunk, err := cs.codec.decodeChunk([]byte(encoded))
+		if err != nil {
+			return Response{}, err
+		}
+
+		body = append(body, chunk...)
+	}
+	resp.Body = body
+
+	return resp, nil
+}
+
+// get returns value with remaining ttl if local cache is enabled
+func (cs *CacheService) get(key string) ([]byte, time.Duration, error) {
+	if cs.local == nil {
+		value, err := cs.rdb.Get(ctx, key).Bytes()
+		return value, 0, err
+	}
+
+	pipe := cs.rdb.Pipeline()
+	getCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	value, err := getCmd.Bytes()
+
+	return value, ttlCmd.Val(), err
+}
+
+func (cs *CacheService) listen(channels []string) {
+	pubsub := cs.rdb.Subscribe(ctx, channels...)
+	defer pubsub.Close()
 
-	return cmd.Err()
+	for msg := range pubsub.ChannelWithSubscriptions(ctx, 100) {
+		switch msg := msg.(type) {
+		case *redis.Subscription:
+			if msg.Kind != "subscribe" {
+				continue
+			}
+
+			// notifications could be missed while reconnecting
+			if cs.local != nil {
+				cs.local.clear()
+			}
+			cs.waiters.setReady(true)
+		case *redis.Message:
+			switch {
+			case msg.Channel == cs.getUnlockChannel():
+				cs.waiters.notify(msg.Payload)
+			case cs.local == nil:
+			case msg.Channel == cs.getInvalidateChannel():
+				cs.local.remove(msg.Payload)
+			case strings.HasPrefix(msg.Payload, cs.prefix):
+				// payload of keyspace notification is key
+				cs.local.remove(strings.TrimPrefix(msg.Payload, cs.prefix))
+			}
+		}
+	}
+
+	cs.waiters.setReady(false)
+}
+
+func (cs *CacheService) getKey(url string) string {
+	return cs.prefix + url
 }
 
 func (cs *CacheService) getLockKey(url string) string {
-	return url + lockKeySuffix
+	return cs.getKey(url) + lockKeySuffix
+}
+
+func (cs *CacheService) getLastKey(url string) string {
+	return cs.getKey(url) + lastKeySuffix
+}
+
+func (cs *CacheService) getTokenKey() string {
+	return cs.prefix + tokenKeyName
+}
+
+func (cs *CacheService) getWriteTokenKey(key string) string {
+	return key + writeTokenKeySuffix
+}
+
+func (cs *CacheService) getInvalidateChannel() string {
+	return cs.prefix + invalidateChannelName
+}
+
+func (cs *CacheService) getUnlockChannel() string {
+	return cs.prefix + unlockChannelName
+}
+
+func (cs *CacheService) getChunkKey(key, chunkID string, index int) string {
+	return fmt.Sprintf("%s%s%s:%d", key, chunkKeySuffix, chunkID, index)
+}
+
+func getRandomChunkID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"ikit-cache/internal/util"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestCacheService(t *testing.T, mr *miniredis.Miniredis, config util.Config) *CacheService {
+	config.RedisURL = "redis://" + mr.Addr()
+	cs := MakeCacheService(&config)
+
+	// wait until subscription to notifications is confirmed
+	assert.Eventually(t, func() bool {
+		_, cancel, err := cs.SubscribeUnlock("")
+		if err != nil {
+			return false
+		}
+
+		cancel()
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	return cs
+}
+
+func TestCacheServiceLock(t *testing.T) {
+	cs := makeTestCacheService(t, miniredis.RunT(t), util.Config{})
+
+	_, isTakeLock, err := cs.Lock("url", "a", time.Second)
+	assert.True(t, isTakeLock)
+	assert.NoError(t, err)
+
+	_, isTakeLock, _ = cs.Lock("url", "b", time.Second)
+	assert.False(t, isTakeLock)
+
+	isExtended, _ := cs.Extend("url", "b", time.Second)
+	assert.False(t, isExtended)
+
+	isExtended, _ = cs.Extend("url", "a", time.Second)
+	assert.True(t, isExtended)
+
+	assert.NoError(t, cs.Unlock("url", "b"))
+	isLock, _ := cs.IsLock("url")
+	assert.True(t, isLock)
+
+	assert.NoError(t, cs.Unlock("url", "a"))
+	isLock, _ = cs.IsLock("url")
+	assert.False(t, isLock)
+}
+
+func TestCacheServiceFencing(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cs := makeTestCacheService(t, mr, util.Config{})
+
+	oldToken, _, _ := cs.Lock("url", "a", time.Second)
+	cs.Unlock("url", "a")
+	newToken, _, _ := cs.Lock("url", "b", time.Second)
+	assert.Greater(t, newToken, oldToken)
+
+	assert.NoError(t, cs.SetResponse("url", Response{Body: []byte("new")}, time.Minute, newToken))
+	assert.ErrorIs(t, cs.SetResponse("url", Response{Body: []byte("old")}, time.Minute, oldToken), ErrStaleToken)
+
+	resp, err := cs.GetResponse("url")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "new", string(resp.Body))
+	}
+
+	assert.NoError(t, cs.SetLastResponse("url", Response{Body: []byte("new")}, time.Minute, newToken))
+	assert.ErrorIs(t, cs.SetLastResponse("url", Response{Body: []byte("old")}, time.Minute, oldToken), ErrStaleToken)
+
+	resp, err = cs.GetLastResponse("url")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "new", string(resp.Body))
+	}
+
+	// token of last write expires with response
+	assert.Equal(t, time.Minute, mr.TTL(cs.getWriteTokenKey("url")))
+	mr.FastForward(time.Minute)
+	assert.False(t, mr.Exists(cs.getWriteTokenKey("url")))
+}
+
+func TestCacheServiceLocalInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	config := util.Config{LocalCacheTTL: 60}
+	writer := makeTestCacheService(t, mr, config)
+	reader := makeTestCacheService(t, mr, config)
+
+	assert.NoError(t, writer.SetResponse("url", Response{Body: []byte("a")}, time.Minute, 1))
+	resp, _ := reader.GetResponse("url")
+	assert.Equal(t, "a", string(resp.Body))
+
+	// response is served from local cache even if redis is changed behind it
+	mr.Del("url")
+	resp, err := reader.GetResponse("url")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "a", string(resp.Body))
+	}
+
+	// overwrite is published to other nodes
+	assert.NoError(t, writer.SetResponse("url", Response{Body: []byte("b")}, time.Minute, 2))
+	assert.Eventually(t, func() bool {
+		resp, err := reader.GetResponse("url")
+		return err == nil && string(resp.Body) == "b"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCacheServiceChunks(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cs := makeTestCacheService(t, mr, util.Config{BodyChunkSize: 4})
+
+	assert.NoError(t, cs.SetResponse("url", Response{Body: []byte("first body")}, time.Hour, 1))
+	var previousChunks []string
+	for _, key := range mr.Keys() {
+		if strings.Contains(key, chunkKeySuffix) {
+			previousChunks = append(previousChunks, key)
+		}
+	}
+	assert.Len(t, previousChunks, 3)
+
+	assert.NoError(t, cs.SetResponse("url", Response{Body: []byte("second body")}, time.Hour, 2))
+	resp, err := cs.GetResponse("url")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "second body", string(resp.Body))
+	}
+
+	// chunks of overwritten entry expire after grace period
+	for _, key := range previousChunks {
+		assert.Equal(t, chunkGracePeriod, mr.TTL(key))
+	}
+
+	mr.FastForward(chunkGracePeriod)
+	for _, key := range previousChunks {
+		assert.False(t, mr.Exists(key))
+	}
+
+	resp, err = cs.GetResponse("url")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "second body", string(resp.Body))
+	}
+}
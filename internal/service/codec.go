@@ -0,0 +1,301 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	entryproto "ikit-cache/internal/service/proto"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+/* cache entry format
+
+legacy entry: JSON of Response
+
+entry: magic (4 bytes) | version (1 byte) | compression (1 byte) | payload
+	version 1: payload is JSON of Response (read only)
+	version 2: payload is protobuf Entry (api/entry.proto)
+	compression: 0 - none, 1 - gzip (payload is compressed only if it's larger than threshold)
+
+chunk: compression (1 byte) | body chunk
+	body of large response is split into chunks which are referenced by chunk id and count of entry
+*/
+
+const (
+	NoCompression   = "none"
+	GzipCompression = "gzip"
+
+	entryMagic      = "\x00ikc"
+	entryHeaderSize = len(entryMagic) + 2
+
+	entryVersionJSON  = 1
+	entryVersionProto = 2
+
+	compressionNone = 0
+	compressionGzip = 1
+)
+
+var (
+	ErrUnknownEntryFormat = errors.New("unknown format of cache entry")
+)
+
+// chunkRef refers to body which is stored in count chunks with id
+type chunkRef struct {
+	id    string
+	count int
+}
+
+type entryCodec struct {
+	compression byte
+	threshold   int
+}
+
+func makeEntryCodec(compression string, threshold int) (entryCodec, error) {
+	switch compression {
+	case "", NoCompression:
+		return entryCodec{compression: compressionNone}, nil
+	case GzipCompression:
+		return entryCodec{compression: compressionGzip, threshold: threshold}, nil
+	default:
+		return entryCodec{}, fmt.Errorf("unknown compression: %s", compression)
+	}
+}
+
+func (ec entryCodec) encode(response Response) ([]byte, error) {
+	return ec.encodeChunked(response, chunkRef{})
+}
+
+// encodeChunked encodes response which body is stored in chunks
+func (ec entryCodec) encodeChunked(response Response, chunks chunkRef) ([]byte, error) {
+	entry := makeEntry(response)
+	entry.ChunkId = chunks.id
+	entry.ChunkCount = int32(chunks.count)
+
+	payload, err := proto.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	compression, payload, err := ec.compress(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([]byte, 0, entryHeaderSize+len(payload))
+	encoded = append(encoded, entryMagic...)
+	encoded = append(encoded, entryVersionProto, compression)
+	encoded = append(encoded, payload...)
+
+	return encoded, nil
+}
+
+func (ec entryCodec) decode(entry []byte) (Response, error) {
+	resp, _, err := ec.decodeChunked(entry)
+	return resp, err
+}
+
+// decodeChunked returns response without body if its body is stored in chunks
+func (ec entryCodec) decodeChunked(entry []byte) (Response, chunkRef, error) {
+	resp := Response{}
+	chunks := chunkRef{}
+
+	// legacy entry without header
+	if !bytes.HasPrefix(entry, []byte(entryMagic)) {
+		resp, err := decodeJSON(entry)
+		return resp, chunks, err
+	}
+
+	if len(entry) < entryHeaderSize {
+		return resp, chunks, ErrUnknownEntryFormat
+	}
+
+	version, compression := entry[len(entryMagic)], entry[len(entryMagic)+1]
+	payload, err := decompress(compression, entry[entryHeaderSize:])
+	if err != nil {
+		return resp, chunks, err
+	}
+
+	switch version {
+	case entryVersionJSON:
+		resp, err := decodeJSON(payload)
+		return resp, chunks, err
+	case entryVersionProto:
+		entry := &entryproto.Entry{}
+		if err := proto.Unmarshal(payload, entry); err != nil {
+			return resp, chunks, err
+		}
+
+		chunks = chunkRef{id: entry.ChunkId, count: int(entry.ChunkCount)}
+
+		return makeResponseFromEntry(entry), chunks, nil
+	default:
+		return resp, chunks, ErrUnknownEntryFormat
+	}
+}
+
+func (ec entryCodec) encodeChunk(chunk []byte) ([]byte, error) {
+	compression, payload, err := ec.compress(chunk)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{compression}, payload...), nil
+}
+
+func (ec entryCodec) decodeChunk(chunk []byte) ([]byte, error) {
+	if len(chunk) == 0 {
+		return nil, ErrUnknownEntryFormat
+	}
+
+	return decompress(chunk[0], chunk[1:])
+}
+
+// compress compresses payload only if it's larger than threshold
+func (ec entryCodec) compress(payload []byte) (byte, []byte, error) {
+	if ec.compression != compressionGzip || len(payload) <= ec.threshold {
+		return compressionNone, payload, nil
+	}
+
+	compressed, err := gzipCompress(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return compressionGzip, compressed, nil
+}
+
+func decompress(compression byte, payload []byte) ([]byte, error) {
+	switch compression {
+	case compressionNone:
+		return payload, nil
+	case compressionGzip:
+		return gzipDecompress(payload)
+	default:
+		return nil, ErrUnknownEntryFormat
+	}
+}
+
+// jsonResponse is Response of JSON entries, body was stored as string
+type jsonResponse struct {
+	Response
+	Body string `json:"response"`
+}
+
+func decodeJSON(payload []byte) (Response, error) {
+	resp := jsonResponse{}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return Response{}, err
+	}
+
+	resp.Response.Body = []byte(resp.Body)
+
+	return resp.Response, nil
+}
+
+func makeEntry(response Response) *entryproto.Entry {
+	entry := &entryproto.Entry{
+		Body:         response.Body,
+		IsError:      response.IsError,
+		ErrorCode:    int32(response.ErrorCode),
+		StatusCode:   int32(response.StatusCode),
+		ContentType:  response.ContentType,
+		FetchLatency: durationpb.New(response.FetchLatency),
+		Etag:         response.ETag,
+		LastModified: response.LastModified,
+		Failures:     int32(response.Failures),
+		Truncated:    response.Truncated,
+	}
+
+	names := make([]string, 0, len(response.Header))
+	for name := range response.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry.Headers = append(entry.Headers, &entryproto.Header{
+			Name:   name,
+			Values: response.Header[name],
+		})
+	}
+
+	if !response.FetchedAt.IsZero() {
+		entry.FetchedAt = timestamppb.New(response.FetchedAt)
+	}
+
+	if !response.ExpiresAt.IsZero() {
+		entry.ExpiresAt = timestamppb.New(response.ExpiresAt)
+	}
+
+	return entry
+}
+
+func makeResponseFromEntry(entry *entryproto.Entry) Response {
+	response := Response{
+		Body:         entry.Body,
+		IsError:      entry.IsError,
+		ErrorCode:    ErrorCode(entry.ErrorCode),
+		StatusCode:   int(entry.StatusCode),
+		ContentType:  entry.ContentType,
+		FetchLatency: entry.FetchLatency.AsDuration(),
+		ETag:         entry.Etag,
+		LastModified: entry.LastModified,
+		Failures:     int(entry.Failures),
+		Truncated:    entry.Truncated,
+	}
+
+	if len(entry.Headers) > 0 {
+		response.Header = make(http.Header, len(entry.Headers))
+		for _, header := range entry.Headers {
+			response.Header[header.Name] = header.Values
+		}
+	}
+
+	response.FetchedAt = timeOf(entry.FetchedAt)
+	response.ExpiresAt = timeOf(entry.ExpiresAt)
+
+	return response
+}
+
+// timeOf returns zero time for nil timestamp
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+
+	return ts.AsTime()
+}
+
+func gzipCompress(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func gzipDecompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
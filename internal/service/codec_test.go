@@ -0,0 +1,102 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryCodec(t *testing.T) {
+	now := time.Date(2021, 3, 20, 12, 0, 0, 0, time.UTC)
+	response := Response{
+		Body:         []byte(strings.Repeat("<html></html>", 100)),
+		StatusCode:   200,
+		Header:       http.Header{"Content-Type": {"text/html"}, "Set-Cookie": {"a=1", "b=2"}},
+		ContentType:  "text/html",
+		FetchedAt:    now,
+		FetchLatency: 100 * time.Millisecond,
+		ExpiresAt:    now.Add(time.Minute),
+		ETag:         `"etag"`,
+		LastModified: now.Format(http.TimeFormat),
+	}
+
+	for _, compression := range []string{NoCompression, GzipCompression} {
+		codec, err := makeEntryCodec(compression, 100)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		entry, err := codec.encode(response)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		decoded, err := codec.decode(entry)
+		if assert.NoError(t, err, compression) {
+			assert.Equal(t, response, decoded, compression)
+		}
+	}
+}
+
+func TestEntryCodecCompressionThreshold(t *testing.T) {
+	codec, _ := makeEntryCodec(GzipCompression, 1024)
+
+	small, _ := codec.encode(Response{Body: []byte("small")})
+	assert.Equal(t, byte(compressionNone), small[len(entryMagic)+1])
+
+	large, _ := codec.encode(Response{Body: []byte(strings.Repeat("large", 1024))})
+	assert.Equal(t, byte(compressionGzip), large[len(entryMagic)+1])
+	assert.Less(t, len(large), 1024)
+}
+
+func TestEntryCodecLegacy(t *testing.T) {
+	codec, _ := makeEntryCodec(GzipCompression, 0)
+
+	resp, err := codec.decode([]byte(`{"response":"body","is_error":false}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "body", string(resp.Body))
+	}
+
+	payload := []byte(`{"response":"body","is_error":false}`)
+	resp, err = codec.decode(append([]byte(entryMagic+"\x01\x00"), payload...))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "body", string(resp.Body))
+	}
+
+	_, err = codec.decode(append([]byte(entryMagic), 100, compressionNone))
+	assert.ErrorIs(t, err, ErrUnknownEntryFormat)
+
+	_, err = codec.decode([]byte(entryMagic))
+	assert.ErrorIs(t, err, ErrUnknownEntryFormat)
+}
+
+func TestEntryCodecChunks(t *testing.T) {
+	codec, _ := makeEntryCodec(GzipCompression, 100)
+
+	entry, err := codec.encodeChunked(Response{StatusCode: 200, Truncated: true}, chunkRef{id: "id", count: 3})
+	if assert.NoError(t, err) {
+		resp, chunks, err := codec.decodeChunked(entry)
+		if assert.NoError(t, err) {
+			assert.Equal(t, chunkRef{id: "id", count: 3}, chunks)
+			assert.True(t, resp.Truncated)
+		}
+	}
+
+	for _, body := range []string{"small", strings.Repeat("large", 1024)} {
+		chunk, err := codec.encodeChunk([]byte(body))
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		decoded, err := codec.decodeChunk(chunk)
+		if assert.NoError(t, err) {
+			assert.Equal(t, body, string(decoded))
+		}
+	}
+
+	_, err = codec.decodeChunk(nil)
+	assert.ErrorIs(t, err, ErrUnknownEntryFormat)
+}
@@ -0,0 +1,51 @@
+package service
+
+import "sync"
+
+type flightCall struct {
+	wg       sync.WaitGroup
+	response Response
+	dups     int
+}
+
+// flightGroup deduplicates concurrent calls with the same key (singleflight)
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*flightCall),
+	}
+}
+
+// do executes fn once for all concurrent callers of key,
+// shared is true for callers which got response of another caller
+func (g *flightGroup) do(key string, fn func() Response) (response Response, shared bool) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		call.dups++
+		g.mu.Unlock()
+		call.wg.Wait()
+
+		return call.response, true
+	}
+
+	call := &flightCall{}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+
+		call.wg.Done()
+	}()
+
+	call.response = fn()
+
+	return call.response, false
+}
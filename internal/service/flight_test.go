@@ -0,0 +1,55 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroupSharesResponse(t *testing.T) {
+	g := newFlightGroup()
+	release := make(chan struct{})
+	var calls, shared int32
+
+	wg := &sync.WaitGroup{}
+	wg.Add(10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer wg.Done()
+
+			resp, isShared := g.do("a", func() Response {
+				atomic.AddInt32(&calls, 1)
+				<-release
+
+				return Response{Body: []byte("a")}
+			})
+
+			assert.Equal(t, "a", string(resp.Body))
+			if isShared {
+				atomic.AddInt32(&shared, 1)
+			}
+		}()
+	}
+
+	// wait until all callers join the first call
+	for {
+		g.mu.Lock()
+		call := g.calls["a"]
+		isJoined := call != nil && call.dups == 9
+		g.mu.Unlock()
+
+		if isJoined {
+			break
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, int32(9), atomic.LoadInt32(&shared))
+
+	_, isShared := g.do("a", func() Response { return Response{} })
+	assert.False(t, isShared)
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// localCache is process-local L1 tier in front of redis,
+// entries live no longer than ttl
+type localCache struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	lru *lruCache
+}
+
+func newLocalCache(ttl time.Duration, maxEntries, maxBytes int) *localCache {
+	return &localCache{
+		ttl: ttl,
+		lru: newLRUCache(maxEntries, maxBytes),
+	}
+}
+
+func (lc *localCache) get(key string) (Response, bool) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	return lc.lru.get(key, time.Now())
+}
+
+// set stores response until min(local ttl, remaining ttl of redis entry)
+func (lc *localCache) set(key string, response Response, expiration time.Duration) {
+	if expiration <= 0 || expiration > lc.ttl {
+		expiration = lc.ttl
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.lru.set(key, response, time.Now().Add(expiration))
+}
+
+func (lc *localCache) remove(key string) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.lru.remove(key)
+}
+
+func (lc *localCache) clear() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.lru = newLRUCache(lc.lru.maxEntries, lc.lru.maxBytes)
+}
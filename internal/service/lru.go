@@ -0,0 +1,105 @@
+package service
+
+import (
+	"container/list"
+	"time"
+)
+
+type lruEntry struct {
+	key       string
+	response  Response
+	size      int
+	expiresAt time.Time
+}
+
+// lruCache isn't safe for concurrent use, callers must hold own lock.
+// Zero maxEntries or maxBytes means no limit.
+type lruCache struct {
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	ll         *list.List
+	items      map[string]*list.Element
+	// onRemove is called for every removed (evicted, expired or replaced) key
+	onRemove func(key string)
+}
+
+func newLRUCache(maxEntries, maxBytes int) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string, now time.Time) (Response, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return Response{}, false
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if now.After(entry.expiresAt) {
+		c.removeElement(elem)
+		return Response{}, false
+	}
+
+	c.ll.MoveToFront(elem)
+
+	return entry.response, true
+}
+
+func (c *lruCache) set(key string, response Response, expiresAt time.Time) {
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+
+	size := len(key) + len(response.Body)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	entry := &lruEntry{
+		key:       key,
+		response:  response,
+		size:      size,
+		expiresAt: expiresAt,
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	c.bytes += size
+
+	for c.isOverflow() {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *lruCache) contains(key string) bool {
+	_, ok := c.items[key]
+	return ok
+}
+
+func (c *lruCache) len() int {
+	return c.ll.Len()
+}
+
+func (c *lruCache) isOverflow() bool {
+	return (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+func (c *lruCache) removeElement(elem *list.Element) {
+	entry := c.ll.Remove(elem).(*lruEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+
+	if c.onRemove != nil {
+		c.onRemove(entry.key)
+	}
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryLock struct {
+	value     string
+	expiresAt time.Time
+}
+
+// expired locks are pruned when number of locks reaches locksPruneSize,
+// it's doubled number of left locks but not less than minLocksPruneSize
+const minLocksPruneSize = 1024
+
+type MemoryCacheService struct {
+	mu             sync.Mutex
+	responses      *lruCache
+	locks          map[string]memoryLock
+	locksPruneSize int
+	// token is one for all urls like token of CacheService,
+	// token of last write is kept while response is cached
+	token       int64
+	writeTokens map[string]int64
+	waiters     *unlockWaiters
+}
+
+func MakeMemoryCacheService(maxEntries, maxBytes int) *MemoryCacheService {
+	ms := &MemoryCacheService{
+		responses:      newLRUCache(maxEntries, maxBytes),
+		locks:          make(map[string]memoryLock),
+		locksPruneSize: minLocksPruneSize,
+		writeTokens:    make(map[string]int64),
+		waiters:        newUnlockWaiters(true),
+	}
+
+	ms.responses.onRemove = func(key string) {
+		delete(ms.writeTokens, key)
+	}
+
+	return ms
+}
+
+func (ms *MemoryCacheService) IsLock(url string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	_, ok := ms.getLock(url)
+
+	return ok, nil
+}
+
+// Lock works like SET NX PX + INCR of token
+func (ms *MemoryCacheService) Lock(url, value string, expiration time.Duration) (int64, bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.getLock(url); ok {
+		return 0, false, nil
+	}
+
+	if len(ms.locks) >= ms.locksPruneSize {
+		ms.pruneLocks()
+	}
+
+	ms.locks[url] = memoryLock{
+		value:     value,
+		expiresAt: time.Now().Add(expiration),
+	}
+	ms.token++
+
+	return ms.token, true, nil
+}
+
+// Unlock deletes lock only if it's owned by value (same as deleteScript)
+func (ms *MemoryCacheService) Unlock(url, value string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if lock, ok := ms.getLock(url); ok && lock.value == value {
+		delete(ms.locks, url)
+		ms.waiters.notify(url)
+	}
+
+	return nil
+}
+
+func (ms *MemoryCacheService) Extend(url, value string, expiration time.Duration) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	lock, ok := ms.getLock(url)
+	if !ok || lock.value != value {
+		return false, nil
+	}
+
+	lock.expiresAt = time.Now().Add(expiration)
+	ms.locks[url] = lock
+
+	return true, nil
+}
+
+func (ms *MemoryCacheService) SubscribeUnlock(url string) (<-chan struct{}, func(), error) {
+	return ms.waiters.subscribe(url)
+}
+
+func (ms *MemoryCacheService) GetResponse(url string) (Response, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	resp, ok := ms.responses.get(url, time.Now())
+	if !ok {
+		return Response{}, ErrCacheMiss
+	}
+
+	return resp, nil
+}
+
+func (ms *MemoryCacheService) SetResponse(url string, response Response, expiration time.Duration, token int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if err := ms.set(url, response, expiration, token); err != nil {
+		return err
+	}
+	ms.waiters.notify(url)
+
+	return nil
+}
+
+func (ms *MemoryCacheService) GetLastResponse(url string) (Response, error) {
+	return ms.GetResponse(url + lastKeySuffix)
+}
+
+func (ms *MemoryCacheService) SetLastResponse(url string, response Response, expiration time.Duration, token int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.set(url+lastKeySuffix, response, expiration, token)
+}
+
+// set works like setScript
+func (ms *MemoryCacheService) set(key string, response Response, expiration time.Duration, token int64) error {
+	if token < ms.writeTokens[key] {
+		return ErrStaleToken
+	}
+
+	ms.responses.set(key, response, time.Now().Add(expiration))
+	if ms.responses.contains(key) {
+		ms.writeTokens[key] = token
+	}
+
+	return nil
+}
+
+func (ms *MemoryCacheService) pruneLocks() {
+	now := time.Now()
+	for url, lock := range ms.locks {
+		if now.After(lock.expiresAt) {
+			delete(ms.locks, url)
+		}
+	}
+
+	ms.locksPruneSize = 2 * len(ms.locks)
+	if ms.locksPruneSize < minLocksPruneSize {
+		ms.locksPruneSize = minLocksPruneSize
+	}
+}
+
+// getLock returns not expired lock and removes expired one
+func (ms *MemoryCacheService) getLock(url string) (memoryLock, bool) {
+	lock, ok := ms.locks[url]
+	if !ok {
+		return memoryLock{}, false
+	}
+
+	if time.Now().After(lock.expiresAt) {
+		delete(ms.locks, url)
+		return memoryLock{}, false
+	}
+
+	return lock, true
+}
@@ -0,0 +1,162 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryResponseExpiration(t *testing.T) {
+	ms := MakeMemoryCacheService(0, 0)
+
+	assert.NoError(t, ms.SetResponse("a", Response{Body: []byte("a")}, time.Hour, 0))
+	assert.NoError(t, ms.SetResponse("b", Response{Body: []byte("b")}, -time.Second, 0))
+
+	resp, err := ms.GetResponse("a")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "a", string(resp.Body))
+	}
+
+	_, err = ms.GetResponse("b")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestMemoryMaxEntries(t *testing.T) {
+	ms := MakeMemoryCacheService(2, 0)
+
+	ms.SetResponse("a", Response{Body: []byte("a")}, time.Hour, 0)
+	ms.SetResponse("b", Response{Body: []byte("b")}, time.Hour, 0)
+	ms.GetResponse("a")
+	ms.SetResponse("c", Response{Body: []byte("c")}, time.Hour, 0)
+
+	_, err := ms.GetResponse("b")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	_, err = ms.GetResponse("a")
+	assert.NoError(t, err)
+	_, err = ms.GetResponse("c")
+	assert.NoError(t, err)
+}
+
+func TestMemoryMaxBytes(t *testing.T) {
+	ms := MakeMemoryCacheService(0, 10)
+
+	ms.SetResponse("a", Response{Body: []byte("1234")}, time.Hour, 0)
+	ms.SetResponse("b", Response{Body: []byte("1234")}, time.Hour, 0)
+	ms.SetResponse("c", Response{Body: []byte("too large body")}, time.Hour, 0)
+
+	_, err := ms.GetResponse("a")
+	assert.NoError(t, err)
+	_, err = ms.GetResponse("c")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	ms.SetResponse("c", Response{Body: []byte("1234")}, time.Hour, 0)
+
+	_, err = ms.GetResponse("b")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Equal(t, 2, ms.responses.len())
+	assert.Equal(t, 10, ms.responses.bytes)
+}
+
+func TestMemoryLock(t *testing.T) {
+	ms := MakeMemoryCacheService(0, 0)
+
+	_, ok, err := ms.Lock("a", "owner", time.Hour)
+	if assert.NoError(t, err) {
+		assert.True(t, ok)
+	}
+
+	_, ok, _ = ms.Lock("a", "other", time.Hour)
+	assert.False(t, ok)
+
+	ms.Unlock("a", "other")
+	isLock, _ := ms.IsLock("a")
+	assert.True(t, isLock)
+
+	ms.Unlock("a", "owner")
+	isLock, _ = ms.IsLock("a")
+	assert.False(t, isLock)
+
+	ms.Lock("b", "owner", -time.Second)
+	_, ok, _ = ms.Lock("b", "other", time.Hour)
+	assert.True(t, ok)
+}
+
+func TestMemoryFencingToken(t *testing.T) {
+	ms := MakeMemoryCacheService(0, 0)
+
+	staleToken, _, _ := ms.Lock("a", "stale", -time.Second)
+	token, ok, _ := ms.Lock("a", "owner", time.Hour)
+	if assert.True(t, ok) {
+		assert.Greater(t, token, staleToken)
+	}
+
+	assert.NoError(t, ms.SetResponse("a", Response{Body: []byte("new")}, time.Hour, token))
+	assert.ErrorIs(t, ms.SetResponse("a", Response{Body: []byte("old")}, time.Hour, staleToken), ErrStaleToken)
+
+	resp, _ := ms.GetResponse("a")
+	assert.Equal(t, "new", string(resp.Body))
+
+	assert.NoError(t, ms.SetLastResponse("a", Response{Body: []byte("new")}, time.Hour, token))
+	assert.ErrorIs(t, ms.SetLastResponse("a", Response{Body: []byte("old")}, time.Hour, staleToken), ErrStaleToken)
+
+	resp, _ = ms.GetLastResponse("a")
+	assert.Equal(t, "new", string(resp.Body))
+}
+
+func TestMemoryExtendLock(t *testing.T) {
+	ms := MakeMemoryCacheService(0, 0)
+
+	ms.Lock("a", "owner", time.Millisecond)
+
+	ok, _ := ms.Extend("a", "other", time.Hour)
+	assert.False(t, ok)
+
+	ok, _ = ms.Extend("a", "owner", time.Hour)
+	assert.True(t, ok)
+
+	time.Sleep(2 * time.Millisecond)
+	isLock, _ := ms.IsLock("a")
+	assert.True(t, isLock)
+}
+
+func TestMemoryUnlockNotification(t *testing.T) {
+	ms := MakeMemoryCacheService(0, 0)
+
+	ms.Lock("a", "owner", time.Hour)
+	unlocked, cancel, err := ms.SubscribeUnlock("a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cancel()
+
+	ms.Unlock("a", "other")
+	select {
+	case <-unlocked:
+		t.Fatal("notified by not owner")
+	default:
+	}
+
+	ms.Unlock("a", "owner")
+	select {
+	case <-unlocked:
+	case <-time.After(time.Second):
+		t.Fatal("not notified after unlock")
+	}
+}
+
+func TestMemoryTokensArePruned(t *testing.T) {
+	ms := MakeMemoryCacheService(1, 0)
+
+	ms.SetResponse("a", Response{Body: []byte("a")}, time.Hour, 1)
+	ms.SetResponse("b", Response{Body: []byte("b")}, time.Hour, 1)
+	assert.NotContains(t, ms.writeTokens, "a")
+	assert.Contains(t, ms.writeTokens, "b")
+
+	for i := 0; i < minLocksPruneSize; i++ {
+		ms.Lock(strconv.Itoa(i), "expired", -time.Second)
+	}
+	ms.Lock("a", "owner", time.Hour)
+	assert.Len(t, ms.locks, 1)
+}
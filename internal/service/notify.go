@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrNotSubscribed = errors.New("not subscribed to unlock notifications")
+)
+
+// UnlockNotifier is implemented by backends which could notify
+// waiters about released locks instead of polling IsLock
+type UnlockNotifier interface {
+	// SubscribeUnlock returns channel which is closed when lock of url is released
+	// or response for url is set, cancel must be called when waiting is over
+	SubscribeUnlock(url string) (unlocked <-chan struct{}, cancel func(), err error)
+}
+
+type unlockWaiters struct {
+	mu      sync.Mutex
+	ready   bool
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newUnlockWaiters(ready bool) *unlockWaiters {
+	return &unlockWaiters{
+		ready:   ready,
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func (uw *unlockWaiters) subscribe(key string) (<-chan struct{}, func(), error) {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+
+	if !uw.ready {
+		return nil, nil, ErrNotSubscribed
+	}
+
+	unlocked := make(chan struct{})
+	if uw.waiters[key] == nil {
+		uw.waiters[key] = make(map[chan struct{}]struct{})
+	}
+	uw.waiters[key][unlocked] = struct{}{}
+
+	cancel := func() {
+		uw.mu.Lock()
+		defer uw.mu.Unlock()
+
+		if _, ok := uw.waiters[key][unlocked]; ok {
+			delete(uw.waiters[key], unlocked)
+			if len(uw.waiters[key]) == 0 {
+				delete(uw.waiters, key)
+			}
+		}
+	}
+
+	return unlocked, cancel, nil
+}
+
+func (uw *unlockWaiters) notify(key string) {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+
+	for unlocked := range uw.waiters[key] {
+		close(unlocked)
+	}
+	delete(uw.waiters, key)
+}
+
+// setReady wakes up all waiters, so they could recheck lock
+// after notifications were possibly missed
+func (uw *unlockWaiters) setReady(ready bool) {
+	uw.mu.Lock()
+	defer uw.mu.Unlock()
+
+	uw.ready = ready
+
+	for key, waiters := range uw.waiters {
+		for unlocked := range waiters {
+			close(unlocked)
+		}
+		delete(uw.waiters, key)
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid options")
+	ErrURLNotAllowed  = errors.New("URL isn't allowed")
+)
+
+// CacheOptions of Get and GetRandomDataStream
+type CacheOptions struct {
+	// MaxAge is a max age of cached response, older one is fetched again
+	MaxAge time.Duration
+	// BypassCache makes HTTP requests without cache and lock
+	BypassCache bool
+}
+
+// StreamOptions of GetRandomDataStream, zero values are taken from config
+type StreamOptions struct {
+	CacheOptions
+
+	// Count is a number of random urls, NumberOfRequests by default
+	Count int
+	// URLs and urls of Groups are picked instead of configured URLs
+	URLs   []string
+	Groups []string
+}
+
+func (co CacheOptions) validate() error {
+	if co.MaxAge < 0 {
+		return fmt.Errorf("%w: negative max age %s", ErrInvalidOptions, co.MaxAge)
+	}
+
+	return nil
+}
+
+// getCount returns number of requests, it's limited by MaxNumberOfRequests or NumberOfRequests
+func (rs *RequestService) getCount(options StreamOptions) (int, error) {
+	if options.Count < 0 {
+		return 0, fmt.Errorf("%w: negative count %d", ErrInvalidOptions, options.Count)
+	}
+
+	if options.Count == 0 {
+		return rs.config.NumberOfRequests, nil
+	}
+
+	if maxCount := rs.getMaxCount(); options.Count > maxCount {
+		return 0, fmt.Errorf("%w: count %d is more than %d", ErrInvalidOptions, options.Count, maxCount)
+	}
+
+	return options.Count, nil
+}
+
+func (rs *RequestService) getMaxCount() int {
+	if rs.config.MaxNumberOfRequests <= 0 {
+		return rs.config.NumberOfRequests
+	}
+
+	return rs.config.MaxNumberOfRequests
+}
+
+// getStreamURLs returns urls which random urls are picked from,
+// only urls of configured URLs or URLGroups and configured groups are allowed
+func (rs *RequestService) getStreamURLs(options StreamOptions) ([]string, error) {
+	if len(options.URLs) == 0 && len(options.Groups) == 0 {
+		return rs.config.URLs, nil
+	}
+
+	urls := make([]string, 0, len(options.URLs))
+	for _, requestURL := range options.URLs {
+		normalizedURL, err := normalizeURL(requestURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid URL %s: %v", ErrInvalidOptions, requestURL, err)
+		}
+
+		if !rs.isConfiguredURL(normalizedURL) {
+			return nil, fmt.Errorf("%w: unknown URL %s", ErrInvalidOptions, requestURL)
+		}
+
+		urls = append(urls, normalizedURL)
+	}
+
+	for _, group := range options.Groups {
+		groupURLs, ok := rs.config.URLGroups[group]
+		if !ok || len(groupURLs) == 0 {
+			return nil, fmt.Errorf("%w: unknown URL group %s", ErrInvalidOptions, group)
+		}
+
+		urls = append(urls, groupURLs...)
+	}
+
+	return urls, nil
+}
+
+// getAllowedURL returns normalized url if it's allowed by allowlist
+func (rs *RequestService) getAllowedURL(requestURL string) (string, error) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid URL %s: %v", ErrInvalidOptions, requestURL, err)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if (scheme != "http" && scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%w: URL %s isn't absolute HTTP URL", ErrInvalidOptions, requestURL)
+	}
+
+	normalizedURL, err := normalizeURL(requestURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid URL %s: %v", ErrInvalidOptions, requestURL, err)
+	}
+
+	if !rs.allowlist.isAllowed(normalizedURL) {
+		return "", fmt.Errorf("%w: %s", ErrURLNotAllowed, requestURL)
+	}
+
+	return normalizedURL, nil
+}
+
+// isConfiguredURL checks normalized url, urls of URLGroups are configured as well as URLs
+func (rs *RequestService) isConfiguredURL(normalizedURL string) bool {
+	return rs.allowlist.urls[normalizedURL]
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"ikit-cache/internal/util"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamOptions(t *testing.T) {
+	config := &util.Config{
+		URLs:                []string{"https://golang.org", "https://www.google.com"},
+		URLGroups:           map[string][]string{"search": {"https://www.duckduckgo.com"}},
+		NumberOfRequests:    3,
+		MaxNumberOfRequests: 5,
+	}
+	rs := MakeRequestService(config, MakeMemoryCacheService(0, 0))
+
+	count, err := rs.getCount(StreamOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, count)
+	}
+
+	count, err = rs.getCount(StreamOptions{Count: 5})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 5, count)
+	}
+
+	_, err = rs.getCount(StreamOptions{Count: 6})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = rs.getCount(StreamOptions{Count: -1})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	urls, err := rs.getStreamURLs(StreamOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, config.URLs, urls)
+	}
+
+	urls, err = rs.getStreamURLs(StreamOptions{URLs: []string{"https://GoLang.org/"}, Groups: []string{"search"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"https://golang.org/", "https://www.duckduckgo.com"}, urls)
+	}
+
+	// url of group is configured as well
+	urls, err = rs.getStreamURLs(StreamOptions{URLs: []string{"https://www.duckduckgo.com"}})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"https://www.duckduckgo.com/"}, urls)
+	}
+
+	_, err = rs.getStreamURLs(StreamOptions{URLs: []string{"https://www.bbc.co.uk"}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+
+	_, err = rs.getStreamURLs(StreamOptions{Groups: []string{"git"}})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
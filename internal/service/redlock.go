@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+/* redlock (https://redis.io/topics/distlock)
+
+lock:
+	1. set nx on every node with timeout much less than lock expiration
+	2. lock is taken if majority of nodes is locked and time left for lock (validity) is positive
+	3. otherwise -> unlock all nodes
+
+unlock: delete lock on all nodes (only if it is owned by value)
+
+extend: same as lock but resets expiration of owned lock instead of set nx
+*/
+
+const (
+	// clockDriftFactor of lock expiration plus clockDriftMin is allowed for clock drift between nodes
+	clockDriftFactor = 0.01
+	clockDriftMin    = 2 * time.Millisecond
+
+	// nodeTimeoutFactor of lock expiration is a time to wait response from every node
+	nodeTimeoutFactor = 0.1
+)
+
+type redlock struct {
+	clients []*redis.Client
+	quorum  int
+}
+
+func newRedlock(clients []*redis.Client) *redlock {
+	return &redlock{
+		clients: clients,
+		quorum:  len(clients)/2 + 1,
+	}
+}
+
+func (rl *redlock) lock(key, value string, expiration time.Duration) (bool, error) {
+	start := time.Now()
+
+	acquired, errs := rl.do(expiration, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		return rdb.SetNX(ctx, key, value, expiration).Result()
+	})
+
+	drift := time.Duration(float64(expiration)*clockDriftFactor) + clockDriftMin
+	validity := expiration - time.Since(start) - drift
+
+	if acquired >= rl.quorum && validity > 0 {
+		return true, nil
+	}
+
+	if err := rl.unlock(key, value); err != nil {
+		errs = append(errs, err)
+	}
+
+	return false, rl.quorumError(errs)
+}
+
+func (rl *redlock) extend(key, value string, expiration time.Duration) (bool, error) {
+	start := time.Now()
+
+	extended, errs := rl.do(expiration, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		return rdb.Eval(ctx, extendScript, []string{key}, value, expiration.Milliseconds()).Bool()
+	})
+
+	drift := time.Duration(float64(expiration)*clockDriftFactor) + clockDriftMin
+	validity := expiration - time.Since(start) - drift
+
+	if extended >= rl.quorum && validity > 0 {
+		return true, nil
+	}
+
+	return false, rl.quorumError(errs)
+}
+
+func (rl *redlock) unlock(key, value string) error {
+	_, errs := rl.do(0, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		return true, rdb.Eval(ctx, deleteScript, []string{key}, value).Err()
+	})
+
+	if len(errs) > 0 {
+		return errs[0]
+	}
+
+	return nil
+}
+
+// isLocked returns true if majority of nodes have the lock
+func (rl *redlock) isLocked(key string) (bool, error) {
+	locked, errs := rl.do(0, func(ctx context.Context, rdb *redis.Client) (bool, error) {
+		err := rdb.Get(ctx, key).Err()
+		if errors.Is(err, redis.Nil) {
+			return false, nil
+		}
+
+		return err == nil, err
+	})
+
+	if locked >= rl.quorum {
+		return true, nil
+	}
+
+	return false, rl.quorumError(errs)
+}
+
+// do runs cmd on all nodes concurrently and returns number of successful results,
+// zero expiration means that default client timeouts are used
+func (rl *redlock) do(expiration time.Duration, cmd func(context.Context, *redis.Client) (bool, error)) (int, []error) {
+	var (
+		mu   sync.Mutex
+		ok   int
+		errs []error
+	)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(rl.clients))
+	for _, rdb := range rl.clients {
+		go func(rdb *redis.Client) {
+			defer wg.Done()
+
+			nodeCtx := ctx
+			if expiration > 0 {
+				var cancel context.CancelFunc
+				nodeCtx, cancel = context.WithTimeout(ctx, time.Duration(float64(expiration)*nodeTimeoutFactor))
+				defer cancel()
+			}
+
+			res, err := cmd(nodeCtx, rdb)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, err)
+			} else if res {
+				ok++
+			}
+		}(rdb)
+	}
+
+	wg.Wait()
+
+	return ok, errs
+}
+
+// quorumError returns error only if errors didn't allow to reach quorum
+func (rl *redlock) quorumError(errs []error) error {
+	if len(errs) > len(rl.clients)-rl.quorum {
+		return errs[0]
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestRedlock(t *testing.T, nodes int) (*redlock, []*miniredis.Miniredis) {
+	servers := make([]*miniredis.Miniredis, 0, nodes)
+	clients := make([]*redis.Client, 0, nodes)
+	for i := 0; i < nodes; i++ {
+		mr := miniredis.RunT(t)
+		servers = append(servers, mr)
+		clients = append(clients, redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	}
+
+	return newRedlock(clients), servers
+}
+
+func TestRedlockLock(t *testing.T) {
+	rl, servers := makeTestRedlock(t, 3)
+
+	isLocked, err := rl.lock("key", "a", time.Second)
+	assert.True(t, isLocked)
+	assert.NoError(t, err)
+
+	isLocked, err = rl.lock("key", "b", time.Second)
+	assert.False(t, isLocked)
+	assert.NoError(t, err)
+
+	isExtended, _ := rl.extend("key", "b", time.Second)
+	assert.False(t, isExtended)
+
+	isExtended, _ = rl.extend("key", "a", time.Second)
+	assert.True(t, isExtended)
+
+	// lock isn't released by other value
+	assert.NoError(t, rl.unlock("key", "b"))
+	isLocked, _ = rl.isLocked("key")
+	assert.True(t, isLocked)
+
+	assert.NoError(t, rl.unlock("key", "a"))
+	isLocked, _ = rl.isLocked("key")
+	assert.False(t, isLocked)
+
+	for _, mr := range servers {
+		assert.False(t, mr.Exists("key"))
+	}
+}
+
+func TestRedlockQuorum(t *testing.T) {
+	rl, servers := makeTestRedlock(t, 3)
+
+	// minority is locked by other value -> lock is taken on majority
+	servers[0].Set("key", "b")
+	isLocked, err := rl.lock("key", "a", time.Second)
+	assert.True(t, isLocked)
+	assert.NoError(t, err)
+	assert.NoError(t, rl.unlock("key", "a"))
+
+	// majority is locked by other value -> acquired minority is released
+	servers[1].Set("key", "b")
+	isLocked, err = rl.lock("key", "a", time.Second)
+	assert.False(t, isLocked)
+	assert.NoError(t, err)
+	assert.False(t, servers[2].Exists("key"))
+	servers[0].Del("key")
+	servers[1].Del("key")
+
+	// one node is down -> quorum is reached by others
+	servers[0].Close()
+	isLocked, err = rl.lock("key", "a", time.Second)
+	assert.True(t, isLocked)
+	assert.NoError(t, err)
+	// error of down node is returned, but lock is released on others
+	assert.Error(t, rl.unlock("key", "a"))
+	assert.False(t, servers[1].Exists("key"))
+
+	// majority of nodes is down -> error
+	servers[1].Close()
+	isLocked, err = rl.lock("key", "a", time.Second)
+	assert.False(t, isLocked)
+	assert.Error(t, err)
+	assert.False(t, servers[2].Exists("key"))
+}
+
+func TestRedlockValidity(t *testing.T) {
+	rl, servers := makeTestRedlock(t, 3)
+
+	// expiration is less than allowed clock drift -> no validity left
+	isLocked, _ := rl.lock("key", "a", clockDriftMin/2)
+	assert.False(t, isLocked)
+
+	for _, mr := range servers {
+		assert.False(t, mr.Exists("key"))
+	}
+}
+
+func TestRedlockQuorumError(t *testing.T) {
+	rl := &redlock{clients: make([]*redis.Client, 5), quorum: 3}
+	errA, errB, errC := errors.New("a"), errors.New("b"), errors.New("c")
+
+	assert.NoError(t, rl.quorumError(nil))
+	assert.NoError(t, rl.quorumError([]error{errA, errB}))
+	assert.Equal(t, errA, rl.quorumError([]error{errA, errB, errC}))
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"ikit-cache/internal/util"
 	"io"
 	"log"
@@ -11,140 +12,574 @@ import (
 	"net/url"
 	"sync"
 	"time"
-
-	"github.com/go-redis/redis/v8"
 )
 
 const (
 	requestTimeout = 5 * time.Second
+
+	// lock is extended every lockExtendInterval while HTTP request is in progress
+	lockExtendInterval = requestTimeout / 3
+
+	// lockRetryBackoff (with jitter) delays retry if lock isn't taken but it isn't held by anyone
+	lockRetryBackoff = 100 * time.Millisecond
+
+	maxRedirects = 10
+)
+
+var (
+	ErrBodyTooLarge = errors.New("response body is too large")
 )
 
 type RequestService struct {
 	config   *util.Config
 	client   *http.Client
-	cacheSvc *CacheService
+	cacheSvc Backend
+	flights  *flightGroup
+
+	statusPolicy      statusPolicy
+	urlStatusPolicies map[string]statusPolicy
+
+	// allowlist of urls of Get
+	allowlist allowlist
+
+	// urls which are revalidated in background
+	revalidations sync.Map
 }
 
-func MakeRequestService(config *util.Config, cacheSvc *CacheService) *RequestService {
+func MakeRequestService(config *util.Config, cacheSvc Backend) *RequestService {
 	client := &http.Client{
 		Timeout: requestTimeout,
 	}
 
-	return &RequestService{
+	globalPolicy, err := makeStatusPolicy(config.StatusPolicy, defaultStatusPolicy)
+	if err != nil {
+		log.Fatalf("couldn't parse status policy: %v", err)
+	}
+
+	urlPolicies := make(map[string]statusPolicy, len(config.URLStatusPolicies))
+	for policyURL, urlPolicy := range config.URLStatusPolicies {
+		normalizedURL, err := normalizeURL(policyURL)
+		if err != nil {
+			log.Fatalf("couldn't parse URL of status policy %s: %v", policyURL, err)
+		}
+
+		if urlPolicies[normalizedURL], err = makeStatusPolicy(urlPolicy, globalPolicy); err != nil {
+			log.Fatalf("couldn't parse status policy of %s: %v", policyURL, err)
+		}
+	}
+
+	allowedURLs := append([]string{}, config.URLs...)
+	for _, groupURLs := range config.URLGroups {
+		allowedURLs = append(allowedURLs, groupURLs...)
+	}
+
+	allowlist, err := makeAllowlist(config.AllowedURLs, allowedURLs)
+	if err != nil {
+		log.Fatalf("couldn't parse allowed URLs: %v", err)
+	}
+
+	rs := &RequestService{
 		config:   config,
 		client:   client,
 		cacheSvc: cacheSvc,
+		flights:  newFlightGroup(),
+
+		statusPolicy:      globalPolicy,
+		urlStatusPolicies: urlPolicies,
+
+		allowlist: allowlist,
 	}
+	client.CheckRedirect = rs.checkRedirect
+
+	return rs
 }
 
-func (rs *RequestService) GetRandomDataStream() <-chan string {
-	responses := make(chan string)
+// Get returns response of url, error wraps ErrInvalidOptions if url or options aren't valid
+// or ErrURLNotAllowed if url isn't allowed
+func (rs *RequestService) Get(requestURL string, options CacheOptions) (Response, error) {
+	if err := options.validate(); err != nil {
+		return Response{}, err
+	}
+
+	normalizedURL, err := rs.getAllowedURL(requestURL)
+	if err != nil {
+		return Response{}, err
+	}
 
-	go rs.makeAsyncRequests(responses)
+	return rs.get(normalizedURL, options), nil
+}
 
-	return responses
+// IndexedResponse is response of url with index in urls of GetMany
+type IndexedResponse struct {
+	Index int
+	Response
 }
 
-func (rs *RequestService) makeAsyncRequests(responses chan<- string) {
+// GetMany returns responses of urls in completion order, errors are the same as errors of Get,
+// number of urls is limited by MaxNumberOfRequests or NumberOfRequests
+func (rs *RequestService) GetMany(urls []string, options CacheOptions) (<-chan IndexedResponse, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("%w: no URLs", ErrInvalidOptions)
+	}
+
+	if maxCount := rs.getMaxCount(); len(urls) > maxCount {
+		return nil, fmt.Errorf("%w: %d URLs is more than %d", ErrInvalidOptions, len(urls), maxCount)
+	}
+
+	normalizedURLs := make([]string, 0, len(urls))
+	for _, requestURL := range urls {
+		normalizedURL, err := rs.getAllowedURL(requestURL)
+		if err != nil {
+			return nil, err
+		}
+
+		normalizedURLs = append(normalizedURLs, normalizedURL)
+	}
+
+	// responses are buffered to not block requests if caller stops reading
+	responses := make(chan IndexedResponse, len(normalizedURLs))
+
+	go rs.makeAsyncGets(normalizedURLs, options, responses)
+
+	return responses, nil
+}
+
+func (rs *RequestService) makeAsyncGets(urls []string, options CacheOptions, responses chan<- IndexedResponse) {
 	wg := &sync.WaitGroup{}
 
-	wg.Add(rs.config.NumberOfRequests)
-	for i := 0; i < rs.config.NumberOfRequests; i++ {
-		url := rs.getRandomURL()
-		go rs.makeAsyncRequestWithCache(url, responses, wg)
+	wg.Add(len(urls))
+	for i, url := range urls {
+		go func(index int, requestURL string) {
+			defer wg.Done()
+			responses <- IndexedResponse{Index: index, Response: rs.get(requestURL, options)}
+		}(i, url)
 	}
 
 	wg.Wait()
 	close(responses)
 }
 
-func (rs *RequestService) makeAsyncRequestWithCache(requestURL string, responses chan<- string, wg *sync.WaitGroup) {
+// GetRandomDataStream returns error wrapping ErrInvalidOptions if options aren't valid
+func (rs *RequestService) GetRandomDataStream(options StreamOptions) (<-chan Response, error) {
+	count, err := rs.getCount(options)
+	if err != nil {
+		return nil, err
+	}
+
+	urls, err := rs.getStreamURLs(options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	// responses are buffered to not block requests if caller stops reading
+	responses := make(chan Response, count)
+
+	go rs.makeAsyncRequests(urls, count, options, responses)
+
+	return responses, nil
+}
+
+func (rs *RequestService) makeAsyncRequests(urls []string, count int, options StreamOptions, responses chan<- Response) {
+	wg := &sync.WaitGroup{}
+
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		url := rs.getRandomURL(urls)
+		go rs.makeAsyncRequestWithCache(url, options, responses, wg)
+	}
+
+	wg.Wait()
+	close(responses)
+}
+
+func (rs *RequestService) makeAsyncRequestWithCache(requestURL string, options StreamOptions, responses chan<- Response, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	// send response to channel, error response is sent with its code and message
+	responses <- rs.get(requestURL, options.CacheOptions)
+}
+
+func (rs *RequestService) get(requestURL string, options CacheOptions) Response {
+	if !options.BypassCache {
+		return rs.getResponse(requestURL, options.MaxAge)
+	}
+
+	requestURL = rs.normalizeURL(requestURL)
+	log.Printf("make HTTP request for %s bypassing cache", requestURL)
+	resp := rs.makeResponse(requestURL, nil)
+	resp.URL = requestURL
+
+	return resp
+}
+
+// getResponse shares one cache lookup / lock / HTTP request between
+// concurrent callers of the same url (and max age) on this node,
+// cached response older than max age isn't served if max age isn't 0
+func (rs *RequestService) getResponse(requestURL string, maxAge time.Duration) Response {
+	requestURL = rs.normalizeURL(requestURL)
+
+	flightKey := requestURL
+	if maxAge > 0 {
+		flightKey = fmt.Sprintf("%s %s", requestURL, maxAge)
+	}
+
+	resp, isShared := rs.flights.do(flightKey, func() Response {
+		resp := rs.getResponseWithCache(requestURL, maxAge)
+		if resp.IsError {
+			return rs.getLastResponse(requestURL, maxAge, resp)
+		}
+
+		return resp
+	})
+
+	if isShared {
+		log.Printf("get coalesced response for %s", requestURL)
+		resp.Source = SourceCoalesced
+	}
+	resp.URL = requestURL
+
+	return resp
+}
+
+// normalizeURL returns url as is if it couldn't be normalized
+func (rs *RequestService) normalizeURL(requestURL string) string {
+	normalizedURL, err := normalizeURL(requestURL)
+	if err != nil {
+		log.Printf("couldn't normalize URL %s: %v", requestURL, err)
+		return requestURL
+	}
+
+	return normalizedURL
+}
+
+func (rs *RequestService) getResponseWithCache(requestURL string, maxAge time.Duration) Response {
 	for {
+		// stale error response isn't served, it's kept to count consecutive failures
+		var previous *Response
+
 		// read response from cache
 		resp, err := rs.cacheSvc.GetResponse(requestURL)
 		if err != nil {
-			if !errors.Is(err, redis.Nil) {
+			if !errors.Is(err, ErrCacheMiss) {
 				log.Printf("couldn't get response from cache for %s: %v", requestURL, err)
 			}
+		} else if resp.IsError && resp.IsStale(time.Now()) {
+			previous = &resp
+		} else if isOlder(resp, maxAge) {
+			log.Printf("response from cache for %s is older than %s", requestURL, maxAge)
+			previous = &resp
+		} else if resp.IsStale(time.Now()) {
+			log.Printf("get stale response from cache for %s", requestURL)
+			rs.revalidate(requestURL, resp)
+			resp.Stale = true
+			resp.Source = SourceStale
+
+			return resp
 		} else {
 			log.Printf("get response from cache for %s", requestURL)
-			if !resp.IsError {
-				responses <- resp.Body
-			}
+			resp.Source = SourceHit
 
-			return
+			return resp
 		}
 
 		// try get lock
-		isTakeLock := false
-		lockValue, err := rs.getRandomLockValue()
-		if err != nil {
-			log.Println("couldn't generate random lock value")
-		} else {
-			isTakeLock, err = rs.cacheSvc.Lock(requestURL, lockValue, requestTimeout)
-			if err != nil {
-				log.Printf("couldn't take lock for %s: %v", requestURL, err)
+		lockValue, lockToken, isTakeLock := rs.takeLock(requestURL)
+		if isTakeLock {
+			if previous == nil {
+				previous = rs.getPreviousResponse(requestURL)
 			}
+
+			return rs.makeRequestWithLock(requestURL, lockValue, lockToken, previous)
 		}
 
 		// wait lock
-		if !isTakeLock {
-			log.Printf("wait lock for %s", requestURL)
-			isGetUnlock := rs.waitLock(requestURL)
+		log.Printf("wait lock for %s", requestURL)
+		isGetUnlock := rs.waitLock(requestURL)
 
-			if isGetUnlock {
-				log.Printf("get unlock for %s", requestURL)
-				continue
-			}
+		if isGetUnlock {
+			log.Printf("get unlock for %s", requestURL)
+			continue
 		}
 
-		// make HTTP request
+		// make HTTP request without lock and cache
 		log.Printf("make HTTP request for %s", requestURL)
-		response := Response{}
-		body, err := rs.makeRequest(requestURL)
+		return rs.makeResponse(requestURL, nil)
+	}
+}
+
+// revalidate refreshes stale response in background if lock could be taken
+func (rs *RequestService) revalidate(requestURL string, stale Response) {
+	if _, isRevalidating := rs.revalidations.LoadOrStore(requestURL, struct{}{}); isRevalidating {
+		return
+	}
+
+	go func() {
+		defer rs.revalidations.Delete(requestURL)
+
+		lockValue, lockToken, isTakeLock := rs.takeLock(requestURL)
+		if !isTakeLock {
+			return
+		}
+
+		log.Printf("revalidate response for %s", requestURL)
+		rs.makeRequestWithLock(requestURL, lockValue, lockToken, &stale)
+	}()
+}
+
+// getPreviousResponse returns last successful response which could be revalidated by conditional request
+func (rs *RequestService) getPreviousResponse(requestURL string) *Response {
+	if rs.config.StaleIfError <= 0 {
+		return nil
+	}
+
+	resp, err := rs.cacheSvc.GetLastResponse(requestURL)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			log.Printf("couldn't get last response from cache for %s: %v", requestURL, err)
+		}
+
+		return nil
+	}
+
+	return &resp
+}
+
+// getLastResponse returns last successful response as stale instead of error response
+// if it isn't older than stale if error window
+func (rs *RequestService) getLastResponse(requestURL string, maxAge time.Duration, errResponse Response) Response {
+	if rs.config.StaleIfError <= 0 {
+		return errResponse
+	}
+
+	resp, err := rs.cacheSvc.GetLastResponse(requestURL)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			log.Printf("couldn't get last response from cache for %s: %v", requestURL, err)
+		}
+
+		return errResponse
+	}
+
+	if time.Since(resp.ExpiresAt) > rs.getStaleIfErrorWindow() || isOlder(resp, maxAge) {
+		return errResponse
+	}
+
+	log.Printf("get last response from cache for %s instead of error", requestURL)
+	resp.Stale = true
+	resp.Source = SourceStale
+
+	return resp
+}
+
+func (rs *RequestService) takeLock(requestURL string) (lockValue string, lockToken int64, isTakeLock bool) {
+	lockValue, err := rs.getRandomLockValue()
+	if err != nil {
+		log.Println("couldn't generate random lock value")
+		return "", 0, false
+	}
+
+	lockToken, isTakeLock, err = rs.cacheSvc.Lock(requestURL, lockValue, requestTimeout)
+	if err != nil {
+		log.Printf("couldn't take lock for %s: %v", requestURL, err)
+	}
+
+	return lockValue, lockToken, isTakeLock
+}
+
+// makeRequestWithLock makes HTTP request (conditional if previous response has validators),
+// sets response to cache and deletes lock, previous error response is used to count consecutive failures
+func (rs *RequestService) makeRequestWithLock(requestURL, lockValue string, lockToken int64, previous *Response) Response {
+	log.Printf("make HTTP request for %s", requestURL)
+	stopExtendLock := rs.extendLock(requestURL, lockValue)
+	response := rs.makeResponse(requestURL, previous)
+	stopExtendLock()
+
+	// set response to cache
+	now := time.Now()
+	expiration, isCacheable := rs.getExpiration(response.Header, now)
+	if response.IsError {
+		response.Failures = 1
+		if previous != nil && previous.IsError {
+			response.Failures = previous.Failures + 1
+		}
+
+		if rs.config.NegativeTimeout > 0 {
+			expiration = rs.getNegativeExpiration(response.Failures)
+		}
+	}
+	response.ExpiresAt = now.Add(expiration)
+
+	// responses of failed HTTP requests have no status
+	if response.StatusCode != 0 && !rs.getStatusPolicy(requestURL).isCacheable(response.StatusCode) {
+		isCacheable = false
+	}
+
+	if isCacheable {
+		rs.setResponse(requestURL, response, expiration, lockToken)
+	} else {
+		log.Printf("response for %s isn't cacheable", requestURL)
+	}
+
+	// delete lock
+	if err := rs.cacheSvc.Unlock(requestURL, lockValue); err != nil {
+		log.Printf("couldn't delete lock for %s: %v", requestURL, err)
+	}
+
+	return response
+}
+
+func (rs *RequestService) setResponse(requestURL string, response Response, expiration time.Duration, lockToken int64) {
+	// error response is kept after expiration to count consecutive failures
+	retention := rs.getStaleWindow()
+	if response.IsError {
+		retention = time.Duration(rs.config.MaxNegativeTimeout) * time.Second
+	}
+
+	if expiration+retention > 0 {
+		err := rs.cacheSvc.SetResponse(requestURL, response, expiration+retention, lockToken)
+		if errors.Is(err, ErrStaleToken) {
+			log.Printf("response for %s is already set by newer lock holder", requestURL)
+			return
+		}
+
 		if err != nil {
-			response.Body = err.Error()
-			response.IsError = true
-		} else {
-			response.Body = body
+			log.Printf("couldn't set response to cache for %s: %v", requestURL, err)
+		}
+	} else {
+		log.Printf("response for %s is expired and there is no stale window", requestURL)
+	}
+
+	if !response.IsError && rs.config.StaleIfError > 0 {
+		err := rs.cacheSvc.SetLastResponse(requestURL, response, expiration+rs.getStaleIfErrorWindow(), lockToken)
+		if errors.Is(err, ErrStaleToken) {
+			log.Printf("last response for %s is already set by newer lock holder", requestURL)
+		} else if err != nil {
+			log.Printf("couldn't set last response to cache for %s: %v", requestURL, err)
 		}
+	}
+}
 
-		// send response to channel
-		if !response.IsError {
-			responses <- response.Body
+func (rs *RequestService) makeResponse(requestURL string, previous *Response) Response {
+	start := time.Now()
+	response, err := rs.makeRequest(requestURL, previous)
+	if err != nil {
+		response = Response{
+			Body:      []byte(err.Error()),
+			IsError:   true,
+			ErrorCode: getErrorCode(err),
 		}
+	}
 
-		if isTakeLock {
-			// set response to cache
-			if err := rs.cacheSvc.SetResponse(requestURL, response, rs.getRandomExpiration()); err != nil {
-				log.Printf("couldn't set response to cache for %s: %v", requestURL, err)
-			}
+	response.FetchedAt = time.Now()
+	response.FetchLatency = response.FetchedAt.Sub(start)
+	response.Source = SourceMiss
 
-			// delete lock
-			if err := rs.cacheSvc.Unlock(requestURL, lockValue); err != nil {
-				log.Printf("couldn't delete lock for %s: %v", requestURL, err)
+	if response.StatusCode != 0 && !rs.getStatusPolicy(requestURL).isSuccess(response.StatusCode) {
+		log.Printf("unsuccessful status of %s: %d", requestURL, response.StatusCode)
+		response.IsError = true
+		response.ErrorCode = ErrorCodeStatus
+	}
+
+	return response
+}
+
+// extendLock extends lock lease in background until returned stop function is called
+// or lock is lost
+func (rs *RequestService) extendLock(requestURL, lockValue string) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(lockExtendInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				isExtended, err := rs.cacheSvc.Extend(requestURL, lockValue, requestTimeout)
+				if err != nil {
+					log.Printf("couldn't extend lock for %s: %v", requestURL, err)
+					continue
+				}
+
+				if !isExtended {
+					log.Printf("lock is lost for %s", requestURL)
+					return
+				}
 			}
 		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
 	}
 }
 
 // true - no lock
 // false - don't wait until unlock
 func (rs *RequestService) waitLock(requestURL string) bool {
+	timeout := time.NewTimer(requestTimeout)
+	defer timeout.Stop()
+
+	if notifier, ok := rs.cacheSvc.(UnlockNotifier); ok {
+		unlocked, cancel, err := notifier.SubscribeUnlock(requestURL)
+		if err == nil {
+			defer cancel()
+			return rs.waitUnlockNotification(requestURL, unlocked, timeout.C)
+		}
+
+		log.Printf("couldn't subscribe to unlock for %s, fallback to polling: %v", requestURL, err)
+	}
+
+	return rs.pollLock(requestURL, timeout.C)
+}
+
+func (rs *RequestService) waitUnlockNotification(requestURL string, unlocked <-chan struct{}, timeout <-chan time.Time) bool {
+	// lock could be released before subscription
+	isLock, err := rs.cacheSvc.IsLock(requestURL)
+	if err != nil {
+		log.Printf("couldn't get in progress status for %s: %v", requestURL, err)
+	}
+
+	// lock couldn't be taken but it isn't held (e.g. it's expired or it's partially acquired),
+	// retry is delayed to not spin on cache
+	var retry <-chan time.Time
+	if err == nil && !isLock {
+		retryTimer := time.NewTimer(rs.getLockRetryBackoff())
+		defer retryTimer.Stop()
+		retry = retryTimer.C
+	}
+
+	select {
+	case <-timeout:
+		return false
+	case <-unlocked:
+		return true
+	case <-retry:
+		return true
+	}
+}
+
+func (rs *RequestService) pollLock(requestURL string, timeout <-chan time.Time) bool {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
-	done := make(chan bool)
-	go func() {
-		time.Sleep(requestTimeout)
-		done <- true
-	}()
-
 	for {
 		select {
-		case <-done:
+		case <-timeout:
 			return false
 		case <-ticker.C:
 			isLock, err := rs.cacheSvc.IsLock(requestURL)
@@ -159,8 +594,25 @@ func (rs *RequestService) waitLock(requestURL string) bool {
 	}
 }
 
-func (rs *RequestService) makeRequest(requestURL string) (string, error) {
-	resp, err := rs.client.Get(requestURL)
+// makeRequest returns previous response with updated headers if origin responds 304 Not Modified
+func (rs *RequestService) makeRequest(requestURL string, previous *Response) (Response, error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+	if err != nil {
+		log.Printf("couldn't create request for %s: %v", requestURL, err)
+		return Response{}, err
+	}
+
+	if previous != nil && !previous.IsError {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	resp, err := rs.client.Do(req)
 	if err != nil {
 		urlErr, ok := err.(*url.Error)
 		if ok && urlErr.Timeout() {
@@ -169,18 +621,102 @@ func (rs *RequestService) makeRequest(requestURL string) (string, error) {
 			log.Printf("couldn't get response from %s: %v", requestURL, err)
 		}
 
-		return "", err
+		return Response{}, err
 	}
 
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		log.Printf("response for %s isn't modified", requestURL)
+
+		// headers of stored response are updated by headers of 304 response
+		response := *previous
+		response.Stale = false
+		response.Header = previous.Header.Clone()
+		if response.Header == nil {
+			response.Header = http.Header{}
+		}
+		for name, values := range resp.Header {
+			response.Header[name] = values
+		}
+		response.ETag = response.Header.Get("ETag")
+		response.LastModified = response.Header.Get("Last-Modified")
+
+		return response, nil
+	}
+
+	body, isTruncated, err := rs.readBody(resp)
 	if err != nil {
 		log.Printf("couldn't read body of %s: %v", requestURL, err)
-		return "", err
+		return Response{}, err
+	}
+
+	if isTruncated {
+		log.Printf("body of %s is truncated", requestURL)
+	}
+
+	response := Response{
+		Body:         body,
+		Truncated:    isTruncated,
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+
+	return response, nil
+}
+
+// checkRedirect follows any redirect of configured URLs and URLGroups,
+// redirect of other url (allowed by AllowedURLs of Get) is followed only to url which is allowed too
+func (rs *RequestService) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	if originalURL, err := normalizeURL(via[0].URL.String()); err == nil && rs.isConfiguredURL(originalURL) {
+		return nil
+	}
+
+	normalizedURL, err := normalizeURL(req.URL.String())
+	if err != nil {
+		return fmt.Errorf("%w: invalid redirect URL %s: %v", ErrURLNotAllowed, req.URL, err)
+	}
+
+	if !rs.allowlist.isAllowed(normalizedURL) {
+		return fmt.Errorf("%w: redirect to %s", ErrURLNotAllowed, req.URL)
 	}
 
-	return string(body), nil
+	return nil
+}
+
+// readBody reads body up to MaxBodySize, larger body is error or it's truncated if TruncateBody is set
+func (rs *RequestService) readBody(resp *http.Response) (body []byte, isTruncated bool, err error) {
+	maxBodySize := int64(rs.config.MaxBodySize)
+	if maxBodySize <= 0 {
+		body, err = io.ReadAll(resp.Body)
+		return body, false, err
+	}
+
+	if resp.ContentLength > maxBodySize && !rs.config.TruncateBody {
+		return nil, false, fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, resp.ContentLength)
+	}
+
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if int64(len(body)) <= maxBodySize {
+		return body, false, nil
+	}
+
+	if !rs.config.TruncateBody {
+		return nil, false, fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxBodySize)
+	}
+
+	return body[:maxBodySize], true, nil
 }
 
 func (rs *RequestService) getRandomLockValue() (string, error) {
@@ -201,9 +737,57 @@ func (rs *RequestService) getRandomExpiration() time.Duration {
 	return time.Duration(rand.Intn(rs.config.MaxTimeout-rs.config.MinTimeout+1)+rs.config.MinTimeout) * time.Second
 }
 
-func (rs *RequestService) getRandomURL() string {
+// getNegativeExpiration returns NegativeTimeout which is doubled for every consecutive failure
+// up to MaxNegativeTimeout
+func (rs *RequestService) getNegativeExpiration(failures int) time.Duration {
+	expiration := time.Duration(rs.config.NegativeTimeout) * time.Second
+	maxExpiration := time.Duration(rs.config.MaxNegativeTimeout) * time.Second
+
+	for i := 1; i < failures && expiration < maxExpiration; i++ {
+		expiration *= 2
+	}
+
+	if maxExpiration > 0 && expiration > maxExpiration {
+		expiration = maxExpiration
+	}
+
+	return expiration
+}
+
+// getLockRetryBackoff returns lockRetryBackoff with jitter in [0.5, 1.5)
+func (rs *RequestService) getLockRetryBackoff() time.Duration {
+	return lockRetryBackoff/2 + time.Duration(rand.Int63n(int64(lockRetryBackoff)))
+}
+
+func (rs *RequestService) getStaleWindow() time.Duration {
+	return time.Duration(rs.config.StaleWindow) * time.Second
+}
+
+func (rs *RequestService) getStaleIfErrorWindow() time.Duration {
+	return time.Duration(rs.config.StaleIfError) * time.Second
+}
+
+func (rs *RequestService) getRandomURL(urls []string) string {
 	rand.Seed(time.Now().UnixNano())
-	randomIndex := rand.Intn(len(rs.config.URLs))
+	randomIndex := rand.Intn(len(urls))
+
+	return urls[randomIndex]
+}
+
+func getErrorCode(err error) ErrorCode {
+	var timeoutErr interface{ Timeout() bool }
+
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		return ErrorCodeBodyTooLarge
+	case errors.As(err, &timeoutErr) && timeoutErr.Timeout():
+		return ErrorCodeTimeout
+	default:
+		return ErrorCodeRequest
+	}
+}
 
-	return rs.config.URLs[randomIndex]
+// isOlder returns false if max age is 0
+func isOlder(resp Response, maxAge time.Duration) bool {
+	return maxAge > 0 && time.Since(resp.FetchedAt) > maxAge
 }
@@ -0,0 +1,360 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"ikit-cache/internal/util"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string { return "timeout" }
+func (timeoutError) Timeout() bool { return true }
+
+func TestGetErrorCode(t *testing.T) {
+	assert.Equal(t, ErrorCodeBodyTooLarge, getErrorCode(fmt.Errorf("%w: 100 bytes", ErrBodyTooLarge)))
+	assert.Equal(t, ErrorCodeTimeout, getErrorCode(&url.Error{Op: "Get", URL: "https://golang.org", Err: timeoutError{}}))
+	assert.Equal(t, ErrorCodeRequest, getErrorCode(&url.Error{Op: "Get", URL: "https://golang.org", Err: errors.New("refused")}))
+}
+
+func TestWaitLockBackoff(t *testing.T) {
+	rs := MakeRequestService(&util.Config{}, MakeMemoryCacheService(0, 0))
+
+	// lock isn't held, so waiter retries after backoff instead of immediately
+	start := time.Now()
+	assert.True(t, rs.waitLock("https://golang.org/"))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(lockRetryBackoff/2))
+	assert.Less(t, int64(time.Since(start)), int64(requestTimeout))
+}
+
+func TestGetMany(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:             []string{origin.URL + "/a", origin.URL + "/b"},
+		MinTimeout:       10,
+		MaxTimeout:       10,
+		NumberOfRequests: 2,
+	}
+	rs := MakeRequestService(config, MakeMemoryCacheService(0, 0))
+
+	responses, err := rs.GetMany(config.URLs, CacheOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	bodies := make([]string, len(config.URLs))
+	for resp := range responses {
+		bodies[resp.Index] = string(resp.Body)
+	}
+	assert.Equal(t, []string{"/a", "/b"}, bodies)
+
+	_, err = rs.GetMany([]string{origin.URL + "/c"}, CacheOptions{})
+	assert.ErrorIs(t, err, ErrURLNotAllowed)
+
+	_, err = rs.GetMany(append(config.URLs, config.URLs...), CacheOptions{})
+	assert.ErrorIs(t, err, ErrInvalidOptions)
+}
+
+func TestGetRandomDataStreamIsBuffered(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:                []string{origin.URL + "/a"},
+		MinTimeout:          10,
+		MaxTimeout:          10,
+		NumberOfRequests:    1,
+		MaxNumberOfRequests: 3,
+	}
+	rs := MakeRequestService(config, MakeMemoryCacheService(0, 0))
+
+	responses, err := rs.GetRandomDataStream(StreamOptions{Count: 3})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// requests aren't blocked if caller doesn't read responses
+	assert.Eventually(t, func() bool {
+		return len(responses) == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConditionalRequest(t *testing.T) {
+	var requests int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=30")
+			w.Header().Set("X-Revalidated", "1")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Original", "1")
+		w.Write([]byte("body"))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:         []string{origin.URL + "/a"},
+		MinTimeout:   10,
+		MaxTimeout:   100,
+		StaleIfError: 60,
+	}
+	rs := MakeRequestService(config, MakeMemoryCacheService(0, 0))
+
+	resp, _ := rs.Get(config.URLs[0], CacheOptions{})
+	assert.Equal(t, SourceMiss, resp.Source)
+	assert.Equal(t, "body", string(resp.Body))
+
+	// no-cache response is revalidated by last response validators
+	now := time.Now()
+	resp, _ = rs.Get(config.URLs[0], CacheOptions{})
+	assert.Equal(t, SourceMiss, resp.Source)
+	assert.Equal(t, "body", string(resp.Body))
+	assert.Equal(t, "1", resp.Header.Get("X-Original"))
+	assert.Equal(t, "1", resp.Header.Get("X-Revalidated"))
+	assert.Equal(t, `"v1"`, resp.ETag)
+	assert.WithinDuration(t, now.Add(30*time.Second), resp.ExpiresAt, time.Second)
+
+	resp, _ = rs.Get(config.URLs[0], CacheOptions{})
+	assert.Equal(t, SourceHit, resp.Source)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// validators of error response aren't sent
+	resp, err := rs.makeRequest(config.URLs[0], &Response{IsError: true, ETag: `"v1"`})
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "body", string(resp.Body))
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:        []string{origin.URL + "/a"},
+		MinTimeout:  10,
+		MaxTimeout:  10,
+		StaleWindow: 10,
+	}
+	cacheSvc := MakeMemoryCacheService(0, 0)
+	rs := MakeRequestService(config, cacheSvc)
+	requestURL := rs.normalizeURL(config.URLs[0])
+
+	stale := Response{Body: []byte("old"), FetchedAt: time.Now().Add(-time.Minute), ExpiresAt: time.Now().Add(-time.Second)}
+	cacheSvc.SetResponse(requestURL, stale, time.Minute, 0)
+
+	resp, _ := rs.Get(config.URLs[0], CacheOptions{})
+	assert.Equal(t, SourceStale, resp.Source)
+	assert.True(t, resp.Stale)
+	assert.Equal(t, "old", string(resp.Body))
+
+	// response is revalidated in background
+	assert.Eventually(t, func() bool {
+		resp, err := cacheSvc.GetResponse(requestURL)
+		return err == nil && string(resp.Body) == "new"
+	}, time.Second, 10*time.Millisecond)
+
+	resp, _ = rs.Get(config.URLs[0], CacheOptions{})
+	assert.Equal(t, SourceHit, resp.Source)
+	assert.False(t, resp.Stale)
+	assert.Equal(t, "new", string(resp.Body))
+}
+
+func TestStaleIfError(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:         []string{origin.URL + "/a", origin.URL + "/b"},
+		MinTimeout:   10,
+		MaxTimeout:   10,
+		StaleIfError: 10,
+	}
+	cacheSvc := MakeMemoryCacheService(0, 0)
+	rs := MakeRequestService(config, cacheSvc)
+
+	last := Response{Body: []byte("last"), FetchedAt: time.Now().Add(-time.Minute), ExpiresAt: time.Now().Add(-time.Second)}
+	cacheSvc.SetLastResponse(rs.normalizeURL(config.URLs[0]), last, time.Minute, 0)
+
+	resp, _ := rs.Get(config.URLs[0], CacheOptions{})
+	assert.False(t, resp.IsError)
+	assert.Equal(t, SourceStale, resp.Source)
+	assert.True(t, resp.Stale)
+	assert.Equal(t, "last", string(resp.Body))
+
+	// last response older than stale if error window isn't served
+	last.ExpiresAt = time.Now().Add(-time.Minute)
+	cacheSvc.SetLastResponse(rs.normalizeURL(config.URLs[1]), last, time.Hour, 0)
+
+	resp, _ = rs.Get(config.URLs[1], CacheOptions{})
+	assert.True(t, resp.IsError)
+	assert.Equal(t, ErrorCodeStatus, resp.ErrorCode)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestReadBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxBodySize   int
+		truncateBody  bool
+		contentLength int64
+		body          string
+		want          string
+		wantTruncated bool
+		wantErr       bool
+	}{
+		{"no limit", 0, false, -1, "0123456789", "0123456789", false, false},
+		{"under limit", 10, false, 10, "0123456789", "0123456789", false, false},
+		{"rejected by content length", 5, false, 10, "0123456789", "", false, true},
+		{"rejected by read", 5, false, -1, "0123456789", "", false, true},
+		{"truncated by content length", 5, true, 10, "0123456789", "01234", true, false},
+		{"truncated by read", 5, true, -1, "0123456789", "01234", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rs := MakeRequestService(&util.Config{MaxBodySize: test.maxBodySize, TruncateBody: test.truncateBody}, nil)
+			resp := &http.Response{
+				Body:          io.NopCloser(strings.NewReader(test.body)),
+				ContentLength: test.contentLength,
+			}
+
+			body, isTruncated, err := rs.readBody(resp)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrBodyTooLarge)
+				return
+			}
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.want, string(body))
+				assert.Equal(t, test.wantTruncated, isTruncated)
+			}
+		})
+	}
+}
+
+func TestRequestedQuery(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:       []string{origin.URL + "/?q=a%20b&flag"},
+		MinTimeout: 10,
+		MaxTimeout: 10,
+	}
+	rs := MakeRequestService(config, MakeMemoryCacheService(0, 0))
+
+	// query params are sorted, but they aren't re-encoded
+	resp, err := rs.Get(config.URLs[0], CacheOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "flag&q=a%20b", string(resp.Body))
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	var target *httptest.Server
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/get/allowed":
+			http.Redirect(w, r, "/get/a", http.StatusFound)
+		case "/get/denied", "/stream":
+			http.Redirect(w, r, target.URL+"/secret", http.StatusFound)
+		default:
+			w.Write([]byte(r.URL.Path))
+		}
+	}))
+	defer origin.Close()
+
+	target = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer target.Close()
+
+	config := &util.Config{
+		URLs:             []string{origin.URL + "/stream"},
+		AllowedURLs:      []string{origin.URL + "/get"},
+		MinTimeout:       10,
+		MaxTimeout:       10,
+		NumberOfRequests: 1,
+	}
+	rs := MakeRequestService(config, MakeMemoryCacheService(0, 0))
+
+	resp, _ := rs.Get(origin.URL+"/get/allowed", CacheOptions{})
+	assert.False(t, resp.IsError)
+	assert.Equal(t, "/get/a", string(resp.Body))
+
+	// redirect of url of Get to url which isn't allowed isn't followed
+	resp, _ = rs.Get(origin.URL+"/get/denied", CacheOptions{})
+	assert.True(t, resp.IsError)
+	assert.Equal(t, ErrorCodeRequest, resp.ErrorCode)
+	assert.NotEqual(t, "secret", string(resp.Body))
+
+	// redirect of configured url is followed anywhere
+	responses, err := rs.GetRandomDataStream(StreamOptions{})
+	if assert.NoError(t, err) {
+		for resp := range responses {
+			assert.False(t, resp.IsError)
+			assert.Equal(t, "secret", string(resp.Body))
+		}
+	}
+}
+
+func TestCachedMetadata(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("body"))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:       []string{origin.URL + "/a"},
+		MinTimeout: 10,
+		MaxTimeout: 10,
+	}
+	rs := MakeRequestService(config, makeTestCacheService(t, miniredis.RunT(t), util.Config{}))
+
+	miss, _ := rs.Get(config.URLs[0], CacheOptions{})
+	hit, _ := rs.Get(config.URLs[0], CacheOptions{})
+	if !assert.Equal(t, SourceHit, hit.Source) {
+		return
+	}
+
+	// metadata of HTTP response is kept in redis entry
+	assert.Equal(t, http.StatusCreated, hit.StatusCode)
+	assert.Equal(t, "text/plain", hit.ContentType)
+	assert.Equal(t, []string{"a=1", "b=2"}, hit.Header.Values("Set-Cookie"))
+	assert.Equal(t, miss.FetchLatency, hit.FetchLatency)
+	assert.True(t, miss.FetchedAt.Equal(hit.FetchedAt))
+	assert.True(t, miss.ExpiresAt.Equal(hit.ExpiresAt))
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"fmt"
+	"ikit-cache/internal/util"
+	"strconv"
+	"strings"
+)
+
+/* status policy
+
+1. status is in NeverCache -> response isn't cached (even if it's success)
+2. status is in Success -> response is sent to channel and cached
+3. status is in CacheableErrors -> response is error and it's cached
+4. other statuses -> response is error and it isn't cached
+
+statuses are codes ("404"), classes ("5xx") or ranges ("500-504"),
+empty lists of URL policy are taken from global policy, empty lists of global policy from default one
+*/
+
+type statusRange struct {
+	from, to int
+}
+
+type statusRanges []statusRange
+
+func (sr statusRanges) contains(code int) bool {
+	for _, r := range sr {
+		if code >= r.from && code <= r.to {
+			return true
+		}
+	}
+
+	return false
+}
+
+type statusPolicy struct {
+	success         statusRanges
+	cacheableErrors statusRanges
+	neverCache      statusRanges
+}
+
+var (
+	defaultStatusPolicy = statusPolicy{
+		success:         statusRanges{{200, 299}},
+		cacheableErrors: statusRanges{{404, 404}, {410, 410}},
+	}
+)
+
+// makeStatusPolicy takes empty lists of config from fallback policy
+func makeStatusPolicy(config util.StatusPolicy, fallback statusPolicy) (statusPolicy, error) {
+	var err error
+	policy := fallback
+
+	if len(config.Success) > 0 {
+		if policy.success, err = parseStatusRanges(config.Success); err != nil {
+			return policy, err
+		}
+	}
+
+	if len(config.CacheableErrors) > 0 {
+		if policy.cacheableErrors, err = parseStatusRanges(config.CacheableErrors); err != nil {
+			return policy, err
+		}
+	}
+
+	if len(config.NeverCache) > 0 {
+		if policy.neverCache, err = parseStatusRanges(config.NeverCache); err != nil {
+			return policy, err
+		}
+	}
+
+	return policy, nil
+}
+
+func (sp statusPolicy) isSuccess(code int) bool {
+	return sp.success.contains(code)
+}
+
+func (sp statusPolicy) isCacheable(code int) bool {
+	if sp.neverCache.contains(code) {
+		return false
+	}
+
+	return sp.success.contains(code) || sp.cacheableErrors.contains(code)
+}
+
+func parseStatusRanges(statuses []string) (statusRanges, error) {
+	ranges := make(statusRanges, 0, len(statuses))
+	for _, status := range statuses {
+		r, err := parseStatusRange(strings.TrimSpace(status))
+		if err != nil {
+			return nil, err
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	return ranges, nil
+}
+
+func parseStatusRange(status string) (statusRange, error) {
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") {
+		class, err := strconv.Atoi(status[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return statusRange{class * 100, class*100 + 99}, nil
+		}
+	}
+
+	if i := strings.Index(status, "-"); i >= 0 {
+		from, fromErr := strconv.Atoi(status[:i])
+		to, toErr := strconv.Atoi(status[i+1:])
+		if fromErr == nil && toErr == nil && from <= to {
+			return statusRange{from, to}, nil
+		}
+	}
+
+	if code, err := strconv.Atoi(status); err == nil {
+		return statusRange{code, code}, nil
+	}
+
+	return statusRange{}, fmt.Errorf("invalid status: %q", status)
+}
+
+// getStatusPolicy returns policy of url or global one
+func (rs *RequestService) getStatusPolicy(requestURL string) statusPolicy {
+	if policy, ok := rs.urlStatusPolicies[requestURL]; ok {
+		return policy
+	}
+
+	return rs.statusPolicy
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"ikit-cache/internal/util"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusRange(t *testing.T) {
+	tests := map[string]statusRange{
+		"200":     {200, 200},
+		"2xx":     {200, 299},
+		"5XX":     {500, 599},
+		"500-504": {500, 504},
+	}
+
+	for status, want := range tests {
+		r, err := parseStatusRange(status)
+		if assert.NoError(t, err, status) {
+			assert.Equal(t, want, r, status)
+		}
+	}
+
+	for _, status := range []string{"", "6xx", "abc", "504-500"} {
+		_, err := parseStatusRange(status)
+		assert.Error(t, err, status)
+	}
+}
+
+func TestStatusPolicy(t *testing.T) {
+	policy, err := makeStatusPolicy(util.StatusPolicy{
+		CacheableErrors: []string{"4xx"},
+		NeverCache:      []string{"206", "429"},
+	}, defaultStatusPolicy)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, policy.isSuccess(200))
+	assert.True(t, policy.isCacheable(200))
+
+	assert.True(t, policy.isSuccess(206))
+	assert.False(t, policy.isCacheable(206))
+
+	assert.False(t, policy.isSuccess(404))
+	assert.True(t, policy.isCacheable(404))
+	assert.False(t, policy.isCacheable(429))
+
+	assert.False(t, policy.isSuccess(500))
+	assert.False(t, policy.isCacheable(500))
+}
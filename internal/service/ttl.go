@@ -0,0 +1,127 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* ttl policy
+
+1. Cache-Control: no-store or private -> response isn't cached (cache is shared between clients)
+2. freshness lifetime:
+	1. Cache-Control: no-cache -> 0 (response must be revalidated)
+	2. Cache-Control: s-maxage
+	3. Cache-Control: max-age
+	4. Expires - Date (Date is replaced by current time if it's absent)
+	5. no headers -> random expiration between MinTimeout and MaxTimeout
+3. expiration = freshness lifetime - Age, clamped to [MinTimeout, MaxTimeout]
+4. zero freshness lifetime (no-cache, max-age=0, Age over lifetime) isn't clamped,
+   response is stored as already expired and it's revalidated on next request
+*/
+
+type freshness struct {
+	lifetime    time.Duration
+	isDefined   bool
+	isCacheable bool
+}
+
+func parseFreshness(header http.Header, now time.Time) freshness {
+	f := freshness{
+		isCacheable: true,
+	}
+
+	var (
+		maxAge, sMaxAge       time.Duration
+		hasMaxAge, hasSMaxAge bool
+		isNoCache             bool
+	)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, arg = directive[:i], strings.Trim(strings.TrimSpace(directive[i+1:]), `"`)
+			}
+
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-store", "private":
+				f.isCacheable = false
+			case "no-cache":
+				isNoCache = true
+			case "max-age":
+				maxAge, hasMaxAge = parseSeconds(arg)
+			case "s-maxage":
+				sMaxAge, hasSMaxAge = parseSeconds(arg)
+			}
+		}
+	}
+
+	switch {
+	case isNoCache:
+		f.lifetime, f.isDefined = 0, true
+	case hasSMaxAge:
+		f.lifetime, f.isDefined = sMaxAge, true
+	case hasMaxAge:
+		f.lifetime, f.isDefined = maxAge, true
+	case header.Get("Expires") != "":
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+
+		// invalid Expires (e.g. "0") means already expired
+		if expires, err := http.ParseTime(header.Get("Expires")); err == nil && expires.After(date) {
+			f.lifetime = expires.Sub(date)
+		}
+		f.isDefined = true
+	}
+
+	if age, ok := parseSeconds(header.Get("Age")); ok && f.isDefined {
+		f.lifetime -= age
+		if f.lifetime < 0 {
+			f.lifetime = 0
+		}
+	}
+
+	return f
+}
+
+func parseSeconds(value string) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+
+	return time.Duration(seconds) * time.Second, true
+}
+
+// getExpiration returns expiration of response by ttl policy,
+// false is returned if response mustn't be cached
+func (rs *RequestService) getExpiration(header http.Header, now time.Time) (time.Duration, bool) {
+	f := parseFreshness(header, now)
+	if !f.isCacheable {
+		return 0, false
+	}
+
+	if !f.isDefined {
+		return rs.getRandomExpiration(), true
+	}
+
+	if f.lifetime == 0 {
+		return 0, true
+	}
+
+	minExpiration := time.Duration(rs.config.MinTimeout) * time.Second
+	maxExpiration := time.Duration(rs.config.MaxTimeout) * time.Second
+
+	if f.lifetime < minExpiration {
+		return minExpiration, true
+	}
+
+	if f.lifetime > maxExpiration {
+		return maxExpiration, true
+	}
+
+	return f.lifetime, true
+}
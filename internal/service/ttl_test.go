@@ -0,0 +1,95 @@
+package service
+
+import (
+	"ikit-cache/internal/util"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFreshness(t *testing.T) {
+	now := time.Date(2021, 3, 20, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   freshness
+	}{
+		{"no headers", http.Header{}, freshness{isCacheable: true}},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=60"}}, freshness{60 * time.Second, true, true}},
+		{"s-maxage", http.Header{"Cache-Control": {"max-age=60, s-maxage=30"}}, freshness{30 * time.Second, true, true}},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=60"}}, freshness{0, true, true}},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, freshness{0, false, false}},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, freshness{60 * time.Second, true, false}},
+		{"age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}}, freshness{40 * time.Second, true, true}},
+		{"old age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"120"}}, freshness{0, true, true}},
+		{
+			"expires",
+			http.Header{
+				"Date":    {now.Format(http.TimeFormat)},
+				"Expires": {now.Add(time.Minute).Format(http.TimeFormat)},
+			},
+			freshness{time.Minute, true, true},
+		},
+		{"invalid expires", http.Header{"Expires": {"0"}}, freshness{0, true, true}},
+		{
+			"max-age over expires",
+			http.Header{
+				"Cache-Control": {"max-age=10"},
+				"Expires":       {now.Add(time.Minute).Format(http.TimeFormat)},
+			},
+			freshness{10 * time.Second, true, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, parseFreshness(test.header, now))
+		})
+	}
+}
+
+func TestGetExpiration(t *testing.T) {
+	rs := MakeRequestService(&util.Config{MinTimeout: 10, MaxTimeout: 100}, nil)
+	now := time.Now()
+
+	expiration, ok := rs.getExpiration(http.Header{"Cache-Control": {"max-age=5"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Second, expiration)
+
+	expiration, _ = rs.getExpiration(http.Header{"Cache-Control": {"max-age=500"}}, now)
+	assert.Equal(t, 100*time.Second, expiration)
+
+	expiration, _ = rs.getExpiration(http.Header{"Cache-Control": {"max-age=50"}}, now)
+	assert.Equal(t, 50*time.Second, expiration)
+
+	expiration, _ = rs.getExpiration(nil, now)
+	assert.GreaterOrEqual(t, expiration, 10*time.Second)
+	assert.LessOrEqual(t, expiration, 100*time.Second)
+
+	_, ok = rs.getExpiration(http.Header{"Cache-Control": {"no-store"}}, now)
+	assert.False(t, ok)
+
+	// zero lifetime isn't clamped to MinTimeout, response is stored as expired
+	expiration, ok = rs.getExpiration(http.Header{"Cache-Control": {"no-cache"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), expiration)
+
+	expiration, _ = rs.getExpiration(http.Header{"Cache-Control": {"max-age=60"}, "Age": {"120"}}, now)
+	assert.Equal(t, time.Duration(0), expiration)
+}
+
+func TestGetNegativeExpiration(t *testing.T) {
+	rs := MakeRequestService(&util.Config{NegativeTimeout: 2, MaxNegativeTimeout: 10}, nil)
+
+	assert.Equal(t, 2*time.Second, rs.getNegativeExpiration(1))
+	assert.Equal(t, 4*time.Second, rs.getNegativeExpiration(2))
+	assert.Equal(t, 8*time.Second, rs.getNegativeExpiration(3))
+	assert.Equal(t, 10*time.Second, rs.getNegativeExpiration(4))
+	assert.Equal(t, 10*time.Second, rs.getNegativeExpiration(100))
+
+	rs = MakeRequestService(&util.Config{NegativeTimeout: 2}, nil)
+	assert.Equal(t, 2*time.Second, rs.getNegativeExpiration(5))
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/* url normalization
+
+urls are normalized before they're used as cache keys and requested:
+	1. scheme and host are lower case
+	2. default port is removed (80 for http, 443 for https)
+	3. empty path is "/" (trailing slash of other paths is significant)
+	4. query params are sorted by name (order of values of the same param is kept),
+	   params aren't decoded and re-encoded (e.g. "?flag" and "%20" are kept as is)
+	5. fragment is removed
+*/
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+func normalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+
+	if host, port, err := net.SplitHostPort(u.Host); err == nil && defaultPorts[u.Scheme] == port {
+		u.Host = host
+		// brackets of IPv6 host are removed by SplitHostPort
+		if strings.Contains(host, ":") {
+			u.Host = "[" + host + "]"
+		}
+	}
+
+	if u.Path == "" && u.Opaque == "" {
+		u.Path = "/"
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery = sortQuery(u.RawQuery)
+	}
+
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String(), nil
+}
+
+// sortQuery sorts raw query params by name, empty params are removed
+func sortQuery(rawQuery string) string {
+	params := make([]string, 0, strings.Count(rawQuery, "&")+1)
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param != "" {
+			params = append(params, param)
+		}
+	}
+
+	sort.SliceStable(params, func(i, j int) bool {
+		return getParamName(params[i]) < getParamName(params[j])
+	})
+
+	return strings.Join(params, "&")
+}
+
+func getParamName(param string) string {
+	if i := strings.Index(param, "="); i >= 0 {
+		return param[:i]
+	}
+
+	return param
+}
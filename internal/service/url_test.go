@@ -0,0 +1,36 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://golang.org", "https://golang.org/"},
+		{"https://golang.org/", "https://golang.org/"},
+		{"HTTPS://GoLang.org/Doc", "https://golang.org/Doc"},
+		{"https://golang.org:443/doc/", "https://golang.org/doc/"},
+		{"http://golang.org:80", "http://golang.org/"},
+		{"http://golang.org:8080", "http://golang.org:8080/"},
+		{"http://[::1]:80/", "http://[::1]/"},
+		{"https://golang.org/?b=2&a=1&b=1", "https://golang.org/?a=1&b=2&b=1"},
+		{"https://golang.org/doc#install", "https://golang.org/doc"},
+		{"https://golang.org/?q=a%20b&flag", "https://golang.org/?flag&q=a%20b"},
+		{"https://golang.org/?b=a+b&&a=%2F", "https://golang.org/?a=%2F&b=a+b"},
+	}
+
+	for _, test := range tests {
+		got, err := normalizeURL(test.url)
+		if assert.NoError(t, err, test.url) {
+			assert.Equal(t, test.want, got, test.url)
+		}
+	}
+
+	_, err := normalizeURL("http://golang.org/%zz")
+	assert.Error(t, err)
+}
@@ -1,24 +1,159 @@
 package transport
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"ikit-cache/internal/service"
 	"ikit-cache/internal/transport/proto"
+	"ikit-cache/internal/util"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// defaultMaxMessageSize is default max message size of gRPC
+const defaultMaxMessageSize = 4 << 20
+
+var sources = map[service.Source]proto.Source{
+	service.SourceMiss:      proto.Source_SOURCE_MISS,
+	service.SourceHit:       proto.Source_SOURCE_HIT,
+	service.SourceStale:     proto.Source_SOURCE_STALE,
+	service.SourceCoalesced: proto.Source_SOURCE_COALESCED,
+}
+
+var errorCodes = map[service.ErrorCode]proto.ErrorCode{
+	service.ErrorCodeUnknown:      proto.ErrorCode_ERROR_CODE_UNKNOWN,
+	service.ErrorCodeStatus:       proto.ErrorCode_ERROR_CODE_HTTP_STATUS,
+	service.ErrorCodeTimeout:      proto.ErrorCode_ERROR_CODE_TIMEOUT,
+	service.ErrorCodeRequest:      proto.ErrorCode_ERROR_CODE_REQUEST_FAILED,
+	service.ErrorCodeBodyTooLarge: proto.ErrorCode_ERROR_CODE_BODY_TOO_LARGE,
+}
+
 type server struct {
-	requestSvc *service.RequestService
+	requestSvc     *service.RequestService
+	chunkSize      int
+	maxMessageSize int
 	proto.UnimplementedRandomServiceServer
 }
 
 func (s *server) GetRandomDataStream(req *proto.GetRandomDataStreamRequest, stream proto.RandomService_GetRandomDataStreamServer) error {
-	for resultString := range s.requestSvc.GetRandomDataStream() {
-		resp := &proto.GetRandomDataStreamResponse{
-			Result: resultString,
+	cacheOptions, err := makeCacheOptions(req.GetMaxAge(), req.GetBypassCache())
+	if err != nil {
+		return err
+	}
+
+	options := service.StreamOptions{
+		CacheOptions: cacheOptions,
+		Count:        int(req.GetCount()),
+		URLs:         req.GetUrls(),
+		Groups:       req.GetGroups(),
+	}
+
+	results, err := s.requestSvc.GetRandomDataStream(options)
+	if err != nil {
+		return makeStatusError(err)
+	}
+
+	for result := range results {
+		for _, resp := range s.makeStreamResponses(result) {
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
 		}
+	}
+
+	return nil
+}
 
-		if err := stream.Send(resp); err != nil {
+func (s *server) Get(ctx context.Context, req *proto.GetRequest) (*proto.GetResponse, error) {
+	options, err := makeCacheOptions(req.GetMaxAge(), req.GetBypassCache())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.requestSvc.Get(req.GetUrl(), options)
+	if err != nil {
+		return nil, makeStatusError(err)
+	}
+
+	resp := makeGetResponse(result)
+	s.limitGetResponse(resp)
+
+	return resp, nil
+}
+
+func (s *server) GetMany(req *proto.GetManyRequest, stream proto.RandomService_GetManyServer) error {
+	options, err := makeCacheOptions(req.GetMaxAge(), req.GetBypassCache())
+	if err != nil {
+		return err
+	}
+
+	results, err := s.requestSvc.GetMany(req.GetUrls(), options)
+	if err != nil {
+		return makeStatusError(err)
+	}
+
+	if req.GetOrder() != proto.Order_ORDER_REQUEST {
+		for result := range results {
+			if err := s.sendGetManyItems(result, stream); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// results which are got before previous ones are buffered until previous ones are added,
+	// items are sent in one message which is split only if it doesn't fit max message size
+	pending := make(map[int]service.IndexedResponse)
+	next := 0
+	resp := &proto.GetManyResponse{}
+	size := 0
+	for result := range results {
+		pending[result.Index] = result
+
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+
+			delete(pending, next)
+			next++
+
+			for _, item := range s.makeGetManyItems(result) {
+				itemSize := protowire.SizeTag(1) + protowire.SizeBytes(protobuf.Size(item))
+				if s.maxMessageSize > 0 && len(resp.Items) > 0 && size+itemSize > s.maxMessageSize {
+					if err := stream.Send(resp); err != nil {
+						return err
+					}
+
+					resp, size = &proto.GetManyResponse{}, 0
+				}
+
+				resp.Items = append(resp.Items, item)
+				size += itemSize
+			}
+		}
+	}
+
+	return stream.Send(resp)
+}
+
+// sendGetManyItems sends every chunk of body as item of its own message
+func (s *server) sendGetManyItems(result service.IndexedResponse, stream proto.RandomService_GetManyServer) error {
+	for _, item := range s.makeGetManyItems(result) {
+		if err := stream.Send(&proto.GetManyResponse{Items: []*proto.GetManyItem{item}}); err != nil {
 			return err
 		}
 	}
@@ -26,10 +161,234 @@ func (s *server) GetRandomDataStream(req *proto.GetRandomDataStreamRequest, stre
 	return nil
 }
 
-func InitGRPCServer(requestSvc *service.RequestService) *grpc.Server {
-	grpcServer := grpc.NewServer()
+// makeGetManyItems splits body larger than chunk size into several items,
+// error response is one item with error instead of body
+func (s *server) makeGetManyItems(result service.IndexedResponse) []*proto.GetManyItem {
+	if result.IsError {
+		return []*proto.GetManyItem{{
+			Index:      int32(result.Index),
+			Response:   makeGetResponse(result.Response),
+			ChunkCount: 1,
+		}}
+	}
+
+	chunks := splitBody(result.Body, s.chunkSize)
+	items := make([]*proto.GetManyItem, 0, len(chunks))
+	for i, chunk := range chunks {
+		items = append(items, &proto.GetManyItem{
+			Index:      int32(result.Index),
+			Response:   &proto.GetResponse{Payload: &proto.GetResponse_Body{Body: chunk}},
+			ChunkIndex: int32(i),
+			ChunkCount: int32(len(chunks)),
+		})
+	}
+
+	first := items[0].Response
+	first.Stale = result.Stale
+	first.Truncated = result.Truncated
+	first.Metadata = makeMetadata(result.Response)
+
+	// body isn't split if chunk size isn't set
+	if len(chunks) == 1 {
+		s.limitGetResponse(first)
+	}
+
+	return items
+}
+
+func makeGetResponse(result service.Response) *proto.GetResponse {
+	resp := &proto.GetResponse{
+		Stale:     result.Stale,
+		Metadata:  makeMetadata(result),
+		Truncated: result.Truncated,
+	}
+
+	if result.IsError {
+		resp.Payload = &proto.GetResponse_Error{Error: makeError(result)}
+	} else {
+		resp.Payload = &proto.GetResponse_Body{Body: result.Body}
+	}
+
+	return resp
+}
+
+// limitGetResponse replaces body which doesn't fit max message size by error
+func (s *server) limitGetResponse(resp *proto.GetResponse) {
+	if size := protobuf.Size(resp); s.maxMessageSize > 0 && size > s.maxMessageSize {
+		resp.Payload = &proto.GetResponse_Error{Error: &proto.Error{
+			Code:    proto.ErrorCode_ERROR_CODE_BODY_TOO_LARGE,
+			Message: fmt.Sprintf("response of %d bytes is larger than max message size %d", size, s.maxMessageSize),
+		}}
+	}
+}
+
+func makeCacheOptions(maxAge *durationpb.Duration, bypassCache bool) (service.CacheOptions, error) {
+	options := service.CacheOptions{
+		BypassCache: bypassCache,
+	}
+
+	if maxAge != nil {
+		if err := maxAge.CheckValid(); err != nil {
+			return options, status.Errorf(codes.InvalidArgument, "invalid max age: %v", err)
+		}
+
+		options.MaxAge = maxAge.AsDuration()
+	}
+
+	return options, nil
+}
+
+func makeStatusError(err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidOptions):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrURLNotAllowed):
+		return status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// makeStreamResponses splits body larger than chunk size into several messages,
+// error response is one message with error instead of body
+func (s *server) makeStreamResponses(result service.Response) []*proto.GetRandomDataStreamResponse {
+	if result.IsError {
+		return []*proto.GetRandomDataStreamResponse{{
+			Stale:      result.Stale,
+			Metadata:   makeMetadata(result),
+			Payload:    &proto.GetRandomDataStreamResponse_Error{Error: makeError(result)},
+			ChunkCount: 1,
+		}}
+	}
+
+	chunks := splitBody(result.Body, s.chunkSize)
+	responses := make([]*proto.GetRandomDataStreamResponse, 0, len(chunks))
+	for i, chunk := range chunks {
+		responses = append(responses, &proto.GetRandomDataStreamResponse{
+			Payload:    &proto.GetRandomDataStreamResponse_Body{Body: chunk},
+			ChunkIndex: int32(i),
+			ChunkCount: int32(len(chunks)),
+		})
+	}
+
+	resp := responses[0]
+	resp.Stale = result.Stale
+	resp.Truncated = result.Truncated
+	resp.Metadata = makeMetadata(result)
+
+	if len(chunks) == 1 {
+		s.limitStreamResponse(resp)
+	}
+
+	return responses
+}
+
+// limitStreamResponse sets deprecated result only if message with body twice fits max message size,
+// body which doesn't fit it is replaced by error
+func (s *server) limitStreamResponse(resp *proto.GetRandomDataStreamResponse) {
+	body := resp.GetBody()
+	size := protobuf.Size(resp)
+	if s.maxMessageSize > 0 && size > s.maxMessageSize {
+		resp.Payload = &proto.GetRandomDataStreamResponse_Error{Error: &proto.Error{
+			Code:    proto.ErrorCode_ERROR_CODE_BODY_TOO_LARGE,
+			Message: fmt.Sprintf("response of %d bytes is larger than max message size %d", size, s.maxMessageSize),
+		}}
+		return
+	}
+
+	if !utf8.Valid(body) {
+		return
+	}
+
+	resp.Result = string(body)
+	if s.maxMessageSize > 0 && protobuf.Size(resp) > s.maxMessageSize {
+		resp.Result = ""
+	}
+}
+
+// splitBody returns one chunk if body isn't larger than chunk size
+func splitBody(body []byte, chunkSize int) [][]byte {
+	if chunkSize <= 0 || len(body) <= chunkSize {
+		return [][]byte{body}
+	}
+
+	chunks := make([][]byte, 0, (len(body)+chunkSize-1)/chunkSize)
+	for start := 0; start < len(body); start += chunkSize {
+		end := start + chunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+
+		chunks = append(chunks, body[start:end])
+	}
+
+	return chunks
+}
+
+// makeError returns status instead of body of origin as message of unsuccessful HTTP status
+func makeError(result service.Response) *proto.Error {
+	message := string(result.Body)
+	if result.StatusCode != 0 {
+		message = fmt.Sprintf("unsuccessful HTTP status: %d", result.StatusCode)
+	}
+
+	return &proto.Error{
+		Code:    errorCodes[result.ErrorCode],
+		Message: message,
+	}
+}
+
+func makeMetadata(result service.Response) *proto.Metadata {
+	headers := make(map[string]string, len(result.Header))
+	for name, values := range result.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	metadata := &proto.Metadata{
+		StatusCode:   int32(result.StatusCode),
+		Headers:      headers,
+		ContentType:  result.ContentType,
+		FetchLatency: durationpb.New(result.FetchLatency),
+		Url:          result.URL,
+		Source:       sources[result.Source],
+	}
+
+	now := time.Now()
+	if !result.FetchedAt.IsZero() {
+		metadata.FetchedAt = timestamppb.New(result.FetchedAt)
+		metadata.Age = durationpb.New(now.Sub(result.FetchedAt))
+	}
+
+	if !result.ExpiresAt.IsZero() {
+		ttl := result.ExpiresAt.Sub(now)
+		if ttl < 0 {
+			ttl = 0
+		}
+
+		metadata.Ttl = durationpb.New(ttl)
+	}
+
+	return metadata
+}
+
+func InitGRPCServer(config *util.Config, requestSvc *service.RequestService) *grpc.Server {
+	maxMessageSize := config.MaxMessageSize
+	if maxMessageSize <= 0 {
+		maxMessageSize = defaultMaxMessageSize
+	}
+
+	if config.BodyChunkSize <= 0 || config.BodyChunkSize >= maxMessageSize {
+		log.Printf("body chunk size %d doesn't fit max message size %d", config.BodyChunkSize, maxMessageSize)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.MaxSendMsgSize(maxMessageSize),
+		grpc.MaxRecvMsgSize(maxMessageSize),
+	)
 	s := &server{
-		requestSvc: requestSvc,
+		requestSvc:     requestSvc,
+		chunkSize:      config.BodyChunkSize,
+		maxMessageSize: maxMessageSize,
 	}
 
 	proto.RegisterRandomServiceServer(grpcServer, s)
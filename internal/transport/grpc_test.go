@@ -0,0 +1,265 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"ikit-cache/internal/service"
+	"ikit-cache/internal/transport/proto"
+	"ikit-cache/internal/util"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+func TestMakeStreamResponses(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize int
+		result    service.Response
+		want      []string
+	}{
+		{"no chunk size", 0, service.Response{Body: []byte("0123456789")}, []string{"0123456789"}},
+		{"small body", 20, service.Response{Body: []byte("0123456789")}, []string{"0123456789"}},
+		{"exact chunks", 5, service.Response{Body: []byte("0123456789")}, []string{"01234", "56789"}},
+		{"last chunk", 4, service.Response{Body: []byte("0123456789")}, []string{"0123", "4567", "89"}},
+		{"empty body", 4, service.Response{}, []string{""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &server{chunkSize: test.chunkSize}
+			responses := s.makeStreamResponses(test.result)
+
+			bodies := make([]string, 0, len(responses))
+			for i, resp := range responses {
+				bodies = append(bodies, string(resp.GetBody()))
+				assert.Equal(t, int32(i), resp.GetChunkIndex())
+				assert.Equal(t, int32(len(test.want)), resp.GetChunkCount())
+				assert.Equal(t, i == 0, resp.GetMetadata() != nil)
+			}
+			assert.Equal(t, test.want, bodies)
+		})
+	}
+
+	// error response is one message with error instead of body
+	s := &server{chunkSize: 4}
+	responses := s.makeStreamResponses(service.Response{Body: []byte("timeout error"), IsError: true, ErrorCode: service.ErrorCodeTimeout})
+	if assert.Len(t, responses, 1) {
+		assert.Equal(t, proto.ErrorCode_ERROR_CODE_TIMEOUT, responses[0].GetError().GetCode())
+		assert.Nil(t, responses[0].GetBody())
+		assert.Equal(t, int32(1), responses[0].GetChunkCount())
+	}
+}
+
+func TestMakeError(t *testing.T) {
+	tests := []struct {
+		name   string
+		result service.Response
+		want   *proto.Error
+	}{
+		{
+			"status",
+			service.Response{Body: []byte("origin body"), StatusCode: 503, ErrorCode: service.ErrorCodeStatus},
+			&proto.Error{Code: proto.ErrorCode_ERROR_CODE_HTTP_STATUS, Message: "unsuccessful HTTP status: 503"},
+		},
+		{
+			"request",
+			service.Response{Body: []byte("connection refused"), ErrorCode: service.ErrorCodeRequest},
+			&proto.Error{Code: proto.ErrorCode_ERROR_CODE_REQUEST_FAILED, Message: "connection refused"},
+		},
+		{
+			"body too large",
+			service.Response{Body: []byte("response body is too large"), ErrorCode: service.ErrorCodeBodyTooLarge},
+			&proto.Error{Code: proto.ErrorCode_ERROR_CODE_BODY_TOO_LARGE, Message: "response body is too large"},
+		},
+		{
+			"cached without code",
+			service.Response{Body: []byte("error")},
+			&proto.Error{Code: proto.ErrorCode_ERROR_CODE_UNKNOWN, Message: "error"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, makeError(test.result))
+		})
+	}
+}
+
+func TestMakeStatusError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"invalid options", fmt.Errorf("%w: count is 0", service.ErrInvalidOptions), codes.InvalidArgument},
+		{"url not allowed", fmt.Errorf("%w: https://golang.org", service.ErrURLNotAllowed), codes.PermissionDenied},
+		{"other", errors.New("other"), codes.Internal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, status.Code(makeStatusError(test.err)))
+		})
+	}
+}
+
+func TestMakeMetadata(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		result  service.Response
+		wantAge time.Duration
+		wantTTL time.Duration
+	}{
+		{"fresh", service.Response{FetchedAt: now.Add(-10 * time.Second), ExpiresAt: now.Add(20 * time.Second)}, 10 * time.Second, 20 * time.Second},
+		{"stale", service.Response{FetchedAt: now.Add(-time.Minute), ExpiresAt: now.Add(-30 * time.Second)}, time.Minute, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := makeMetadata(test.result)
+			assert.InDelta(t, test.wantAge, metadata.GetAge().AsDuration(), float64(time.Second))
+			assert.InDelta(t, test.wantTTL, metadata.GetTtl().AsDuration(), float64(time.Second))
+		})
+	}
+
+	// age and ttl aren't set without times
+	metadata := makeMetadata(service.Response{Header: http.Header{"X-A": {"1", "2"}}, Source: service.SourceHit})
+	assert.Nil(t, metadata.GetAge())
+	assert.Nil(t, metadata.GetTtl())
+	assert.Equal(t, map[string]string{"X-A": "1, 2"}, metadata.GetHeaders())
+	assert.Equal(t, proto.Source_SOURCE_HIT, metadata.GetSource())
+}
+
+type getManyStream struct {
+	grpc.ServerStream
+	responses []*proto.GetManyResponse
+}
+
+func (s *getManyStream) Send(resp *proto.GetManyResponse) error {
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func TestGetManyOrder(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// later urls complete first
+		if r.URL.Path == "/a" {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer origin.Close()
+
+	config := &util.Config{
+		URLs:             []string{origin.URL + "/a", origin.URL + "/b", origin.URL + "/c"},
+		MinTimeout:       10,
+		MaxTimeout:       10,
+		NumberOfRequests: 3,
+	}
+	s := &server{
+		requestSvc: service.MakeRequestService(config, service.MakeMemoryCacheService(0, 0)),
+		chunkSize:  1,
+	}
+
+	tests := []struct {
+		name           string
+		order          proto.Order
+		maxMessageSize int
+		wantMessages   int
+	}{
+		{"completion", proto.Order_ORDER_COMPLETION, 0, 6},
+		{"request", proto.Order_ORDER_REQUEST, 0, 1},
+		{"split request", proto.Order_ORDER_REQUEST, 400, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.maxMessageSize = test.maxMessageSize
+			stream := &getManyStream{}
+			err := s.GetMany(&proto.GetManyRequest{Urls: config.URLs, Order: test.order, BypassCache: true}, stream)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// chunks of body are sent one after another
+			var indexes []int32
+			bodies := make(map[int32]string)
+			for _, resp := range stream.responses {
+				if test.order == proto.Order_ORDER_COMPLETION {
+					assert.Len(t, resp.GetItems(), 1)
+				}
+
+				if test.maxMessageSize > 0 {
+					assert.LessOrEqual(t, protobuf.Size(resp), test.maxMessageSize)
+				}
+
+				for _, item := range resp.GetItems() {
+					assert.Equal(t, int32(len(bodies[item.GetIndex()])), item.GetChunkIndex())
+					assert.Equal(t, int32(2), item.GetChunkCount())
+					assert.Equal(t, item.GetChunkIndex() == 0, item.GetResponse().GetMetadata() != nil)
+
+					indexes = append(indexes, item.GetIndex())
+					bodies[item.GetIndex()] += string(item.GetResponse().GetBody())
+				}
+			}
+
+			assert.Equal(t, map[int32]string{0: "/a", 1: "/b", 2: "/c"}, bodies)
+			if test.wantMessages > 0 {
+				assert.Len(t, stream.responses, test.wantMessages)
+			} else {
+				assert.Greater(t, len(stream.responses), 1)
+			}
+
+			if test.order == proto.Order_ORDER_REQUEST {
+				assert.Equal(t, []int32{0, 0, 1, 1, 2, 2}, indexes)
+			} else {
+				assert.Equal(t, []int32{0, 0}, indexes[len(indexes)-2:])
+			}
+		})
+	}
+}
+
+func TestLimitGetResponse(t *testing.T) {
+	s := &server{maxMessageSize: 100}
+
+	resp := makeGetResponse(service.Response{Body: []byte("small body")})
+	s.limitGetResponse(resp)
+	assert.Equal(t, "small body", string(resp.GetBody()))
+
+	// body which doesn't fit max message size is error
+	resp = makeGetResponse(service.Response{Body: make([]byte, 100)})
+	s.limitGetResponse(resp)
+	assert.Nil(t, resp.GetBody())
+	assert.Equal(t, proto.ErrorCode_ERROR_CODE_BODY_TOO_LARGE, resp.GetError().GetCode())
+}
+
+func TestLimitStreamResponse(t *testing.T) {
+	s := &server{maxMessageSize: 100}
+
+	responses := s.makeStreamResponses(service.Response{Body: []byte("small body")})
+	assert.Equal(t, "small body", string(responses[0].GetBody()))
+	assert.Equal(t, "small body", responses[0].GetResult())
+
+	// deprecated result isn't set if body twice doesn't fit max message size
+	body := strings.Repeat("a", 60)
+	responses = s.makeStreamResponses(service.Response{Body: []byte(body)})
+	assert.Equal(t, body, string(responses[0].GetBody()))
+	assert.Empty(t, responses[0].GetResult())
+
+	// body which doesn't fit max message size is error
+	responses = s.makeStreamResponses(service.Response{Body: make([]byte, 100)})
+	assert.Nil(t, responses[0].GetBody())
+	assert.Equal(t, proto.ErrorCode_ERROR_CODE_BODY_TOO_LARGE, responses[0].GetError().GetCode())
+}
@@ -19,6 +19,11 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type RandomServiceClient interface {
 	GetRandomDataStream(ctx context.Context, in *GetRandomDataStreamRequest, opts ...grpc.CallOption) (RandomService_GetRandomDataStreamClient, error)
+	// Get returns response of url which is allowed by AllowedURLs, URLs or URLGroups of server config
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// GetMany returns responses of urls which are allowed the same as urls of Get,
+	// number of urls is limited by MaxNumberOfRequests of server config
+	GetMany(ctx context.Context, in *GetManyRequest, opts ...grpc.CallOption) (RandomService_GetManyClient, error)
 }
 
 type randomServiceClient struct {
@@ -61,11 +66,57 @@ func (x *randomServiceGetRandomDataStreamClient) Recv() (*GetRandomDataStreamRes
 	return m, nil
 }
 
+func (c *randomServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/cache.RandomService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *randomServiceClient) GetMany(ctx context.Context, in *GetManyRequest, opts ...grpc.CallOption) (RandomService_GetManyClient, error) {
+	stream, err := c.cc.NewStream(ctx, &RandomService_ServiceDesc.Streams[1], "/cache.RandomService/GetMany", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &randomServiceGetManyClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type RandomService_GetManyClient interface {
+	Recv() (*GetManyResponse, error)
+	grpc.ClientStream
+}
+
+type randomServiceGetManyClient struct {
+	grpc.ClientStream
+}
+
+func (x *randomServiceGetManyClient) Recv() (*GetManyResponse, error) {
+	m := new(GetManyResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // RandomServiceServer is the server API for RandomService service.
 // All implementations must embed UnimplementedRandomServiceServer
 // for forward compatibility
 type RandomServiceServer interface {
 	GetRandomDataStream(*GetRandomDataStreamRequest, RandomService_GetRandomDataStreamServer) error
+	// Get returns response of url which is allowed by AllowedURLs, URLs or URLGroups of server config
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// GetMany returns responses of urls which are allowed the same as urls of Get,
+	// number of urls is limited by MaxNumberOfRequests of server config
+	GetMany(*GetManyRequest, RandomService_GetManyServer) error
 	mustEmbedUnimplementedRandomServiceServer()
 }
 
@@ -76,6 +127,12 @@ type UnimplementedRandomServiceServer struct {
 func (UnimplementedRandomServiceServer) GetRandomDataStream(*GetRandomDataStreamRequest, RandomService_GetRandomDataStreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method GetRandomDataStream not implemented")
 }
+func (UnimplementedRandomServiceServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedRandomServiceServer) GetMany(*GetManyRequest, RandomService_GetManyServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetMany not implemented")
+}
 func (UnimplementedRandomServiceServer) mustEmbedUnimplementedRandomServiceServer() {}
 
 // UnsafeRandomServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -110,19 +167,68 @@ func (x *randomServiceGetRandomDataStreamServer) Send(m *GetRandomDataStreamResp
 	return x.ServerStream.SendMsg(m)
 }
 
+func _RandomService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RandomServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cache.RandomService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RandomServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RandomService_GetMany_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetManyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RandomServiceServer).GetMany(m, &randomServiceGetManyServer{stream})
+}
+
+type RandomService_GetManyServer interface {
+	Send(*GetManyResponse) error
+	grpc.ServerStream
+}
+
+type randomServiceGetManyServer struct {
+	grpc.ServerStream
+}
+
+func (x *randomServiceGetManyServer) Send(m *GetManyResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // RandomService_ServiceDesc is the grpc.ServiceDesc for RandomService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
 var RandomService_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "cache.RandomService",
 	HandlerType: (*RandomServiceServer)(nil),
-	Methods:     []grpc.MethodDesc{},
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _RandomService_Get_Handler,
+		},
+	},
 	Streams: []grpc.StreamDesc{
 		{
 			StreamName:    "GetRandomDataStream",
 			Handler:       _RandomService_GetRandomDataStream_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "GetMany",
+			Handler:       _RandomService_GetMany_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "cache.proto",
 }
@@ -9,11 +9,41 @@ import (
 )
 
 type Config struct {
-	RedisURL         string   `yaml:"RedisURL"`
-	URLs             []string `yaml:"URLs"`
-	MinTimeout       int      `yaml:"MinTimeout"`
-	MaxTimeout       int      `yaml:"MaxTimeout"`
-	NumberOfRequests int      `yaml:"NumberOfRequests"`
+	CacheBackend         string   `yaml:"CacheBackend"`
+	RedisURL             string   `yaml:"RedisURL"`
+	RedisLockURLs        []string `yaml:"RedisLockURLs"`
+	KeyPrefix            string   `yaml:"KeyPrefix"`
+	Compression          string   `yaml:"Compression"`
+	CompressionThreshold int      `yaml:"CompressionThreshold"`
+	LocalCacheTTL        int      `yaml:"LocalCacheTTL"`
+	LocalCacheMaxEntries int      `yaml:"LocalCacheMaxEntries"`
+	LocalCacheMaxBytes   int      `yaml:"LocalCacheMaxBytes"`
+	MemoryMaxEntries     int      `yaml:"MemoryMaxEntries"`
+	MemoryMaxBytes       int      `yaml:"MemoryMaxBytes"`
+	URLs                 []string `yaml:"URLs"`
+	MinTimeout           int      `yaml:"MinTimeout"`
+	MaxTimeout           int      `yaml:"MaxTimeout"`
+	NegativeTimeout      int      `yaml:"NegativeTimeout"`
+	MaxNegativeTimeout   int      `yaml:"MaxNegativeTimeout"`
+	StaleWindow          int      `yaml:"StaleWindow"`
+	StaleIfError         int      `yaml:"StaleIfError"`
+	NumberOfRequests     int      `yaml:"NumberOfRequests"`
+	MaxNumberOfRequests  int      `yaml:"MaxNumberOfRequests"`
+	MaxBodySize          int      `yaml:"MaxBodySize"`
+	TruncateBody         bool     `yaml:"TruncateBody"`
+	BodyChunkSize        int      `yaml:"BodyChunkSize"`
+	MaxMessageSize       int      `yaml:"MaxMessageSize"`
+
+	URLGroups         map[string][]string     `yaml:"URLGroups"`
+	AllowedURLs       []string                `yaml:"AllowedURLs"`
+	StatusPolicy      StatusPolicy            `yaml:"StatusPolicy"`
+	URLStatusPolicies map[string]StatusPolicy `yaml:"URLStatusPolicies"`
+}
+
+type StatusPolicy struct {
+	Success         []string `yaml:"Success"`
+	CacheableErrors []string `yaml:"CacheableErrors"`
+	NeverCache      []string `yaml:"NeverCache"`
 }
 
 func GetConfig(path string) (*Config, error) {
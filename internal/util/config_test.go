@@ -15,6 +15,18 @@ URLs:
 MinTimeout: 10
 MaxTimeout: 100
 NumberOfRequests: 3	
+`
+
+	statusPolicyConfig = `
+StatusPolicy:
+  Success:
+  - 2xx
+  NeverCache:
+  - 5xx
+URLStatusPolicies:
+  https://golang.org:
+    CacheableErrors:
+    - 404
 `
 )
 
@@ -30,3 +42,15 @@ func TestSimpleConfig(t *testing.T) {
 		assert.Equal(t, 3, config.NumberOfRequests)
 	}
 }
+
+func TestStatusPolicyConfig(t *testing.T) {
+	reader := strings.NewReader(statusPolicyConfig)
+	config := &Config{}
+	err := config.parseConfig(reader)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"2xx"}, config.StatusPolicy.Success)
+		assert.Equal(t, []string{"5xx"}, config.StatusPolicy.NeverCache)
+		assert.Equal(t, []string{"404"}, config.URLStatusPolicies["https://golang.org"].CacheableErrors)
+	}
+}